@@ -0,0 +1,304 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/argoproj/dev-tools/cmd/run/run"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/exponent-io/jsonpath"
+)
+
+// clusterDeleteTimeout keeps misbehaving docker from blocking the exit indefinitely
+const clusterDeleteTimeout = 2 * time.Minute
+
+// healthCheckTimeout bounds checking the health of a cluster considered for reuse
+const healthCheckTimeout = 30 * time.Second
+
+// nsDeleteTimeout bounds deleting a namespace, that can get stuck on finalizers of its resources
+const nsDeleteTimeout = 3 * time.Minute
+
+// clusterSpecConfigMap records the digest of the spec the cluster was created from, so a reused cluster is recreated
+// once the spec changes.
+const clusterSpecConfigMap = "argo-dev-tools-cluster-spec"
+
+// specDigester is implemented by the providers shaping their clusters by a spec.
+type specDigester interface {
+	// SpecDigest identifies the spec the named cluster is created from.
+	SpecDigest(name string) (string, error)
+}
+
+type KubeCluster struct {
+	Name        string
+	Namespace   string
+	ContextName string
+	Provider    Provider
+	// Kubeconfig is the file with the credentials of this cluster only, the default kubeconfig is never modified
+	Kubeconfig string
+	// Reused reports the cluster existed before, so it can contain resources of previous runs
+	Reused bool
+	// cleanup deletes the cluster on exit, unless it is Close()d sooner, nil when the cluster is kept
+	cleanup *run.CleanupHook
+	// kubeconfigCleanup removes the Kubeconfig file
+	kubeconfigCleanup *run.CleanupHook
+}
+
+// Options configure the lifecycle of a KubeCluster.
+type Options struct {
+	// Reuse attaches to a healthy cluster of the same name and spec instead of recreating it
+	Reuse bool
+	// Keep leaves the cluster running on exit
+	Keep bool
+}
+
+// NewCluster creates the named cluster using the provider, replacing eventual leftovers of the same name,
+// unless reused.
+func NewCluster(ctx context.Context, provider Provider, name string, opts Options) (*KubeCluster, error) {
+	kubeconfig, err := run.WriteTemp("argo-dev-tools-kubeconfig-"+name+"-*", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := &KubeCluster{Name: name, Provider: provider, Kubeconfig: kubeconfig}
+	cluster.kubeconfigCleanup = run.OnCleanup(ctx, "kubeconfig "+cluster.Kubeconfig, 0, func(context.Context) error {
+		// Never created under dry-run
+		return os.RemoveAll(cluster.Kubeconfig)
+	})
+	if !opts.Keep {
+		// Registered before the creation, to clean up even half provisioned resources
+		cluster.cleanup = run.OnCleanup(ctx, provider.Name()+" cluster "+name, clusterDeleteTimeout, cluster.teardown)
+	}
+
+	if err := cluster.provision(ctx, opts.Reuse); err != nil {
+		cluster.Close()
+		return nil, err
+	}
+	// Known only once created, for some providers
+	cluster.ContextName = provider.ContextName(name)
+	if !cluster.Reused {
+		if err := cluster.recordSpec(ctx); err != nil {
+			cluster.Close()
+			return nil, err
+		}
+	}
+
+	return cluster, nil
+}
+
+func (c *KubeCluster) provision(ctx context.Context, reuse bool) error {
+	if _, existing := c.Provider.(*ExistingProvider); existing {
+		c.Reused = true
+		return c.Provider.Create(ctx, c.Name, c.Kubeconfig)
+	}
+
+	if reuse {
+		c.ContextName = c.Provider.ContextName(c.Name)
+		err := c.Provider.WriteKubeconfig(ctx, c.Name, c.Kubeconfig)
+		if err == nil {
+			err = c.checkHealth(ctx)
+		}
+		if err == nil {
+			err = c.checkSpec(ctx)
+		}
+		if err == nil {
+			run.Out(os.Stderr, "Reusing KubeCluster "+c.Name)
+			c.Reused = true
+			return nil
+		}
+		run.Out(os.Stderr, "Not reusing KubeCluster %s, recreating it: %s", c.Name, err)
+	}
+
+	// Delete eventual leftovers from previous runs
+	deleteCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), clusterDeleteTimeout)
+	if err := c.Provider.Delete(deleteCtx, c.Name); err != nil {
+		run.Out(os.Stderr, "Failed deleting leftover KubeCluster %s: %s", c.Name, err)
+	}
+	cancel()
+
+	if err := c.Provider.Create(ctx, c.Name, c.Kubeconfig); err != nil {
+		return err
+	}
+	run.Emit(run.Event{Type: run.EventClusterCreated, Cluster: c.Name})
+	return nil
+}
+
+// checkHealth verifies the API server of the cluster is ready.
+func (c *KubeCluster) checkHealth(ctx context.Context) error {
+	mp := c.Proc(ctx, "kubectl", "--context", c.ContextName, "get", "--raw", "/readyz")
+	mp.CaptureStdout()
+	mp.Timeout = healthCheckTimeout
+	return mp.Run()
+}
+
+// checkSpec verifies the cluster was created from the current spec of the provider, as recorded by recordSpec.
+func (c *KubeCluster) checkSpec(ctx context.Context) error {
+	provider, ok := c.Provider.(specDigester)
+	if !ok {
+		return nil
+	}
+	digest, err := provider.SpecDigest(c.Name)
+	if err != nil {
+		return err
+	}
+
+	proc := c.Proc(
+		ctx,
+		"kubectl", "--context", c.ContextName, "-n", "kube-system",
+		"get", "configmap", clusterSpecConfigMap, "--ignore-not-found", "-o", "jsonpath={.data.digest}",
+	)
+	stdout := proc.CaptureStdout()
+	if err := proc.Run(); err != nil {
+		return fmt.Errorf("failed reading spec of KubeCluster %s: %w", c.Name, err)
+	}
+	// Missing record means the cluster was not fully created, or by some other tool
+	if strings.TrimSpace(stdout.String()) != digest {
+		return errors.New("cluster spec changed")
+	}
+	return nil
+}
+
+// recordSpec stores the digest of the spec in the created cluster, for checkSpec of the next runs.
+func (c *KubeCluster) recordSpec(ctx context.Context) error {
+	provider, ok := c.Provider.(specDigester)
+	if !ok {
+		return nil
+	}
+	digest, err := provider.SpecDigest(c.Name)
+	if err != nil {
+		return err
+	}
+
+	err = c.Proc(
+		ctx,
+		"kubectl", "--context", c.ContextName, "-n", "kube-system",
+		"create", "configmap", clusterSpecConfigMap, "--from-literal=digest="+digest,
+	).Run()
+	if err != nil {
+		return fmt.Errorf("failed recording spec of KubeCluster %s: %w", c.Name, err)
+	}
+	return nil
+}
+
+// Close deletes the cluster, unless it has been already, or it is kept.
+func (c *KubeCluster) Close() {
+	if c.cleanup == nil {
+		run.Out(os.Stderr, "Keeping KubeCluster "+c.Name)
+	} else {
+		_ = c.cleanup.Run()
+	}
+	_ = c.kubeconfigCleanup.Run()
+}
+
+func (c *KubeCluster) teardown(ctx context.Context) error {
+	run.Out(os.Stderr, "Closing KubeCluster "+c.Name)
+	if err := c.Provider.Delete(ctx, c.Name); err != nil {
+		return err
+	}
+	run.Out(os.Stderr, "Closed KubeCluster "+c.Name)
+	run.Emit(run.Event{Type: run.EventClusterDeleted, Cluster: c.Name})
+	return nil
+}
+
+// LoadImage makes the local docker image available to the cluster nodes.
+func (c *KubeCluster) LoadImage(ctx context.Context, image string) error {
+	return c.Provider.LoadImage(ctx, c.Name, image)
+}
+
+// HostAddress is the address the cluster is reachable on from the host.
+func (c *KubeCluster) HostAddress(ctx context.Context) (string, error) {
+	return c.Provider.HostAddress(ctx, c.Name)
+}
+
+func (c *KubeCluster) CreateNs(ctx context.Context, ns string) error {
+	mp := c.Proc(ctx, "kubectl", "--context", c.ContextName, "create", "namespace", ns)
+	if err := mp.Run(); err != nil {
+		return err
+	}
+	run.Emit(run.Event{Type: run.EventNamespaceCreated, Cluster: c.Name, Namespace: ns})
+
+	return nil
+}
+
+// DeleteNs deletes the namespace with all its resources, if it exists.
+func (c *KubeCluster) DeleteNs(ctx context.Context, ns string) error {
+	mp := c.Proc(ctx, "kubectl", "--context", c.ContextName, "delete", "namespace", ns, "--ignore-not-found", "--wait")
+	mp.Timeout = nsDeleteTimeout
+	return mp.Run()
+}
+
+func (c *KubeCluster) UseNs(ctx context.Context, ns string) error {
+	c.Namespace = ns
+
+	// Needed by the `make` targets
+	mp := c.Proc(ctx, "kubectl", "config", "set-context", "--current", "--namespace="+ns)
+	if err := mp.Run(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *KubeCluster) KubectlProc(ctx context.Context, args ...string) *run.ManagedProc {
+	if c.Namespace == "" {
+		panic("namespace not set for cluster " + c.Name)
+	}
+
+	args = append([]string{"kubectl", "--context", c.ContextName, "-n", c.Namespace}, args...)
+	return c.Proc(ctx, args...)
+}
+
+// Proc creates a process that talks to this cluster by default, like `make` targets running kubectl.
+func (c *KubeCluster) Proc(ctx context.Context, args ...string) *run.ManagedProc {
+	mp := run.NewManagedProc(ctx, args...)
+	mp.AddEnv("KUBECONFIG", c.Kubeconfig)
+	return mp
+}
+
+// IsNotFound checks if the error is kubectl reporting the requested resource does not exist.
+func IsNotFound(err error) bool {
+	var procErr *run.ProcessError
+	return errors.As(err, &procErr) && procErr.StderrContains("(NotFound)")
+}
+
+func (c *KubeCluster) KubectlGetJson(ctx context.Context, args ...string) (*jsonpath.Decoder, error) {
+	args = append([]string{"get", "--output=json"}, args...)
+	proc := c.KubectlProc(ctx, args...)
+	stdout := proc.CaptureStdout()
+	if err := proc.Run(); err != nil {
+		return nil, err
+	}
+
+	reader := bytes.NewReader(stdout.Bytes())
+	return jsonpath.NewDecoder(reader), nil
+}
+
+func (c *KubeCluster) WaitForAllPodsRunning(ctx context.Context) error {
+	poller := run.Poller{Description: "all pods to be running in " + c.Name, Interval: 5 * time.Second}
+	return poller.Poll(ctx, func() error {
+		proc := c.KubectlProc(
+			ctx,
+			"get",
+			"pods",
+			"--all-namespaces",
+			"--field-selector=status.phase!=Running",
+			"--no-headers",
+		)
+		stdout := proc.CaptureStdout()
+		if err := proc.Run(); err != nil {
+			return run.StopPolling(err)
+		}
+
+		var problems []string
+		for l := range strings.Lines(stdout.String()) {
+			problems = append(problems, l)
+		}
+		if len(problems) == 0 {
+			return nil
+		}
+		return fmt.Errorf("pending:\n- %v", strings.Join(problems, "- "))
+	})
+}
@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/argoproj/dev-tools/cmd/run/run"
+)
+
+// ExistingProvider uses a cluster provisioned outside the tool, like on CI. It is never created nor deleted,
+// and all the names refer to the same cluster.
+type ExistingProvider struct {
+	// Context in kubeconfig, resolved to the current context on Create when empty.
+	Context string
+}
+
+func (p *ExistingProvider) Name() string {
+	return "existing"
+}
+
+func (p *ExistingProvider) ContextName(string) string {
+	return p.Context
+}
+
+// Create checks the context exists, and copies it to the kubeconfig file.
+func (p *ExistingProvider) Create(ctx context.Context, name string, kubeconfig string) error {
+	if p.Context == "" {
+		proc := run.NewManagedProc(ctx, "kubectl", "config", "current-context")
+		stdout := proc.CaptureStdout()
+		if err := proc.Run(); err != nil {
+			return fmt.Errorf("no current kubeconfig context: %w", err)
+		}
+		p.Context = strings.TrimSpace(stdout.String())
+	} else if err := run.NewManagedProc(ctx, "kubectl", "config", "get-contexts", p.Context).Run(); err != nil {
+		return fmt.Errorf("no kubeconfig context %q: %w", p.Context, err)
+	}
+	return p.WriteKubeconfig(ctx, name, kubeconfig)
+}
+
+// WriteKubeconfig copies just the context, with the credentials inlined, so the original kubeconfig stays untouched.
+func (p *ExistingProvider) WriteKubeconfig(ctx context.Context, _ string, kubeconfig string) error {
+	return writeKubeconfig(ctx, kubeconfig, "kubectl", "config", "view", "--minify", "--flatten", "--context", p.Context)
+}
+
+// Delete leaves the cluster running, as it is not owned by the tool.
+func (p *ExistingProvider) Delete(context.Context, string) error {
+	return nil
+}
+
+func (p *ExistingProvider) LoadImage(_ context.Context, _ string, image string) error {
+	return fmt.Errorf("existing cluster cannot load local image %s, push it to a registry the cluster pulls from", image)
+}
+
+func (p *ExistingProvider) HostAddress(ctx context.Context, _ string) (string, error) {
+	proc := run.NewManagedProc(ctx, "kubectl", "config", "view", "--minify", "--context", p.Context, "-o", "jsonpath={.clusters[0].cluster.server}")
+	stdout := proc.CaptureStdout()
+	if err := proc.Run(); err != nil {
+		return "", err
+	}
+
+	server, err := url.Parse(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		return "", fmt.Errorf("invalid server of context %q: %w", p.Context, err)
+	}
+	return server.Hostname(), nil
+}
@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/argoproj/dev-tools/cmd/run/run"
+)
+
+// importedImagesConfigMap records the digests of the images imported into the cluster, so unchanged images are not
+// imported again. Living in the cluster, the record is gone once the cluster is recreated.
+const importedImagesConfigMap = "argo-dev-tools-imported-images"
+
+var alwaysPullPolicy = regexp.MustCompile(`imagePullPolicy:\s*Always`)
+
+// LocalImage is built on the host and imported into the clusters, in place of the released image.
+type LocalImage struct {
+	// Name the image is built as
+	Name string
+	// Replaces is the repository of the released image, like quay.io/argoproj/argocd
+	Replaces string
+}
+
+// Rewrite points the references of the released image in the manifests to the local one, whatever the tag or digest.
+// Images are pulled only when not present, as the local image cannot be pulled at all.
+func (i LocalImage) Rewrite(manifests string) string {
+	released := regexp.MustCompile(regexp.QuoteMeta(i.Replaces) + `(:[\w][\w.-]*)?(@sha256:[0-9a-f]+)?(["'\s]|$)`)
+	manifests = released.ReplaceAllString(manifests, i.Name+"${3}")
+	return alwaysPullPolicy.ReplaceAllString(manifests, "imagePullPolicy: IfNotPresent")
+}
+
+// ImportImage loads the local docker image into the cluster, unless the same image has been imported already.
+func (c *KubeCluster) ImportImage(ctx context.Context, image string) error {
+	if run.IsDryRun() {
+		// No image is built to look the digest up of
+		return c.LoadImage(ctx, image)
+	}
+
+	digest, err := imageDigest(ctx, image)
+	if err != nil {
+		return err
+	}
+	// ConfigMap keys cannot contain colons
+	key := strings.ReplaceAll(digest, ":", "-")
+
+	imported, err := c.importedImage(ctx, key)
+	if err != nil {
+		return err
+	}
+	if imported == image {
+		run.Out(os.Stderr, "Image %s unchanged, not importing it to KubeCluster %s again", image, c.Name)
+		return nil
+	}
+
+	if err := c.LoadImage(ctx, image); err != nil {
+		return fmt.Errorf("failed importing image %s: %w", image, err)
+	}
+	return c.recordImportedImage(ctx, key, image)
+}
+
+func (c *KubeCluster) importedImage(ctx context.Context, key string) (string, error) {
+	proc := c.Proc(
+		ctx,
+		"kubectl", "--context", c.ContextName, "-n", "kube-system",
+		"get", "configmap", importedImagesConfigMap, "--ignore-not-found", "-o", "jsonpath={.data."+key+"}",
+	)
+	stdout := proc.CaptureStdout()
+	if err := proc.Run(); err != nil {
+		return "", fmt.Errorf("failed reading images imported to KubeCluster %s: %w", c.Name, err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (c *KubeCluster) recordImportedImage(ctx context.Context, key string, image string) error {
+	patch := fmt.Sprintf(`{"data":{%q:%q}}`, key, image)
+	proc := c.Proc(
+		ctx,
+		"kubectl", "--context", c.ContextName, "-n", "kube-system",
+		"patch", "configmap", importedImagesConfigMap, "--type=merge", "-p", patch,
+	)
+	// Missing ConfigMap is expected for the first image, still reported by the error
+	proc.Stderr.Silence()
+	err := proc.Run()
+	if IsNotFound(err) {
+		err = c.Proc(
+			ctx,
+			"kubectl", "--context", c.ContextName, "-n", "kube-system",
+			"create", "configmap", importedImagesConfigMap, "--from-literal="+key+"="+image,
+		).Run()
+	}
+	if err != nil {
+		return fmt.Errorf("failed recording image imported to KubeCluster %s: %w", c.Name, err)
+	}
+	return nil
+}
+
+// imageDigest identifies the content of the local docker image.
+func imageDigest(ctx context.Context, image string) (string, error) {
+	proc := run.NewManagedProc(ctx, "docker", "image", "inspect", "--format", "{{.Id}}", image)
+	stdout := proc.CaptureStdout()
+	if err := proc.Run(); err != nil {
+		return "", fmt.Errorf("failed inspecting image %s: %w", image, err)
+	}
+
+	digest := strings.TrimSpace(stdout.String())
+	if digest == "" {
+		return "", fmt.Errorf("no digest of image %s", image)
+	}
+	return digest, nil
+}
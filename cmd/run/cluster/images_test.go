@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/argoproj/dev-tools/cmd/run/run"
+)
+
+func TestLocalImageRewrite(t *testing.T) {
+	image := LocalImage{Name: "argo-dev-tools/argocd:local", Replaces: "quay.io/argoproj/argocd"}
+	manifest := `containers:
+- image: quay.io/argoproj/argocd:v3.0.1
+  imagePullPolicy: Always
+- image: "quay.io/argoproj/argocd@sha256:0123abcd"
+- image: quay.io/argoproj/argocd
+- image: quay.io/argoproj/argocd-agent:v0.1.0
+`
+	expected := `containers:
+- image: argo-dev-tools/argocd:local
+  imagePullPolicy: IfNotPresent
+- image: "argo-dev-tools/argocd:local"
+- image: argo-dev-tools/argocd:local
+- image: quay.io/argoproj/argocd-agent:v0.1.0
+`
+	if rewritten := image.Rewrite(manifest); rewritten != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, rewritten)
+	}
+}
+
+func TestImportImage(t *testing.T) {
+	fake := run.UseFakeExecutor(t)
+	fake.On(`^docker image inspect `, run.FakeResponse{Stdout: "sha256:0123abcd\n"})
+	fake.On(` get configmap argo-dev-tools-imported-images `, run.FakeResponse{Stdout: "argo-dev-tools/argocd:local", Times: 1})
+	fake.On(` patch configmap argo-dev-tools-imported-images `, run.FakeResponse{ExitCode: 1, Stderr: "Error from server (NotFound): not found\n"})
+	fake.On(`.*`, run.FakeResponse{})
+
+	c := &KubeCluster{Name: "test", ContextName: "k3d-test", Provider: K3dProvider{}}
+	// Recorded by the first lookup
+	if err := c.ImportImage(t.Context(), "argo-dev-tools/argocd:local"); err != nil {
+		t.Fatalf("ImportImage() failed: %v", err)
+	}
+	if fake.Count(`^k3d image import `) != 0 {
+		t.Errorf("expected unchanged image not imported: %q", fake.Invocations())
+	}
+
+	if err := c.ImportImage(t.Context(), "argo-dev-tools/argocd:local"); err != nil {
+		t.Fatalf("ImportImage() failed: %v", err)
+	}
+	if fake.Count(`^k3d image import argo-dev-tools/argocd:local --cluster test$`) != 1 {
+		t.Errorf("expected changed image imported: %q", fake.Invocations())
+	}
+	if fake.Count(` create configmap argo-dev-tools-imported-images --from-literal=sha256-0123abcd=argo-dev-tools/argocd:local$`) != 1 {
+		t.Errorf("expected imported image recorded: %q", fake.Invocations())
+	}
+}
@@ -1,149 +1,59 @@
 package cluster
 
 import (
-	"bytes"
-	"github.com/argoproj/dev-tools/cmd/run/run"
+	"context"
 	"os"
-	"os/exec"
-	"strings"
-	"sync"
-	"time"
 
-	"github.com/exponent-io/jsonpath"
+	"github.com/argoproj/dev-tools/cmd/run/run"
 )
 
-// kubeConfigMu guards concurrent access to ~/.kube/config that otherwise leads to error
-var kubeConfigMu sync.Mutex
-
-type KubeCluster struct {
-	Name        string
-	Namespace   string
-	ContextName string
-	// trackerClose prevents program completion on interrupt
-	trackerClose func()
+// K3dProvider runs k3s clusters in docker.
+type K3dProvider struct {
+	Spec Spec
 }
 
-func NewK3dCluster(name string) (c *KubeCluster, err error) {
-	cluster := &KubeCluster{name, "", "k3d-" + name, func() {}}
-	// Delete eventual leftovers from previous runs
-	cluster.Close()
-
-	// Clean up even half provisioned resources in case NewK3dCluster itself fails
-	defer func() {
-		if err != nil {
-			cluster.Close()
-		}
-	}()
-
-	// Create pseudo-task for cluster ownership, to prevent interruption before the cluster is Close()d
-	// The context is actually not needed, just the task
-	_, cluster.trackerClose = run.MainTt.UseContext("k3d_cluster")
-
-	mp := cluster.newCreateProc(run.GetOutboundIP())
-	if err := mp.Run(); err != nil {
-		return nil, err
-	}
-
-	return cluster, nil
+func (K3dProvider) Name() string {
+	return "k3d"
 }
 
-func (c *KubeCluster) Close() {
-	defer c.trackerClose()
+func (K3dProvider) ContextName(name string) string {
+	return "k3d-" + name
+}
 
-	run.Out(os.Stderr, "Closing KubeCluster "+c.Name)
-	// cannot use NewManagedProc - run after main context is cancelled
-	err := exec.Command("k3d", "cluster", "delete", c.Name).Run()
+func (p K3dProvider) Create(ctx context.Context, name string, kubeconfig string) error {
+	config, err := p.Spec.Render(name)
 	if err != nil {
-		run.Out(os.Stderr, "Failed to close KubeCluster %s: %s", c.Name, err)
-		return
+		return err
 	}
-	run.Out(os.Stderr, "Closed KubeCluster "+c.Name)
-}
-
-func (c *KubeCluster) CreateNs(ns string) error {
-	mp := run.NewManagedProc("kubectl", "--context", c.ContextName, "create", "namespace", ns)
-	if err := mp.Run(); err != nil {
+	configFile, err := run.WriteTemp("argo-dev-tools-k3d-"+name+"-*.yaml", config)
+	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(configFile)
 
-	return nil
-}
-
-func (c *KubeCluster) UseNs(ns string) error {
-	kubeConfigMu.Lock()
-	defer kubeConfigMu.Unlock()
-	c.Namespace = ns
-
-	// Needed by the `make` targets
-	mp := run.NewManagedProc("kubectl", "config", "set-context", "--current", "--namespace="+ns)
-	if err := mp.Run(); err != nil {
+	if err := run.NewManagedProc(ctx, "k3d", "cluster", "create", "--config", configFile, name).Run(); err != nil {
 		return err
 	}
-
-	return nil
+	return p.WriteKubeconfig(ctx, name, kubeconfig)
 }
 
-func (c *KubeCluster) newCreateProc(ip string) *run.ManagedProc {
-	return run.NewManagedProc(
-		"k3d", "cluster", "create",
-		"--wait",
-		"--k3s-arg", "--disable=traefik@server:*",
-		//"--api-port", ip+":6550",
-		//"-p", "443:443@loadbalancer",
-		c.Name,
-	)
+func (p K3dProvider) SpecDigest(name string) (string, error) {
+	return p.Spec.Digest(name)
 }
 
-func (c *KubeCluster) KubectlProc(args ...string) *run.ManagedProc {
-	if c.Namespace == "" {
-		panic("namespace not set for cluster " + c.Name)
-	}
-
-	args = append([]string{"kubectl", "--context", c.ContextName, "-n", c.Namespace}, args...)
-	return run.NewManagedProc(args...)
+func (K3dProvider) WriteKubeconfig(ctx context.Context, name string, kubeconfig string) error {
+	return writeKubeconfig(ctx, kubeconfig, "k3d", "kubeconfig", "get", name)
 }
 
-func (c *KubeCluster) KubectlGetJson(args ...string) (*jsonpath.Decoder, error) {
-	args = append([]string{"get", "--output=json"}, args...)
-	proc := c.KubectlProc(args...)
-	stdout := proc.CaptureStdout()
-
-	err := proc.Run()
-	if err != nil {
-		return nil, err
-	}
-
-	reader := bytes.NewReader(stdout.Bytes())
-	return jsonpath.NewDecoder(reader), nil
+func (K3dProvider) Delete(ctx context.Context, name string) error {
+	// cannot use NewManagedProc - run after main context is cancelled
+	return run.NewCleanupProc(ctx, "k3d", "cluster", "delete", name).Run()
 }
 
-func (c *KubeCluster) WaitForAllPodsRunning() error {
-	for {
-		proc := c.KubectlProc(
-			"get",
-			"pods",
-			"--all-namespaces",
-			"--field-selector=status.phase!=Running",
-			"--no-headers",
-		)
-		stdout := proc.CaptureStdout()
-		err := proc.Run()
-		if err != nil {
-			return err
-		}
-
-		var problems []string
-		for l := range strings.Lines(stdout.String()) {
-			problems = append(problems, l)
-		}
-		if len(problems) == 0 {
-			return nil
-		}
+func (K3dProvider) LoadImage(ctx context.Context, name string, image string) error {
+	return run.NewManagedProc(ctx, "k3d", "image", "import", image, "--cluster", name).Run()
+}
 
-		run.Out(
-			os.Stderr, "Waiting for all pods to be running in %s. Waiting on:\n- %v",
-			c.Name, strings.Join(problems, "- "),
-		)
-		time.Sleep(5 * time.Second)
-	}
+func (K3dProvider) HostAddress(ctx context.Context, name string) (string, error) {
+	return containerAddress(ctx, "k3d-"+name+"-server-0")
 }
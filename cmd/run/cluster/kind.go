@@ -0,0 +1,39 @@
+package cluster
+
+import (
+	"context"
+
+	"github.com/argoproj/dev-tools/cmd/run/run"
+)
+
+// KindProvider runs Kubernetes clusters in docker, using kind.
+type KindProvider struct{}
+
+func (KindProvider) Name() string {
+	return "kind"
+}
+
+func (KindProvider) ContextName(name string) string {
+	return "kind-" + name
+}
+
+func (KindProvider) Create(ctx context.Context, name string, kubeconfig string) error {
+	return run.NewManagedProc(ctx, "kind", "create", "cluster", "--name", name, "--wait", "5m", "--kubeconfig", kubeconfig).Run()
+}
+
+func (KindProvider) WriteKubeconfig(ctx context.Context, name string, kubeconfig string) error {
+	return writeKubeconfig(ctx, kubeconfig, "kind", "get", "kubeconfig", "--name", name)
+}
+
+func (KindProvider) Delete(ctx context.Context, name string) error {
+	// cannot use NewManagedProc - run after main context is cancelled
+	return run.NewCleanupProc(ctx, "kind", "delete", "cluster", "--name", name).Run()
+}
+
+func (KindProvider) LoadImage(ctx context.Context, name string, image string) error {
+	return run.NewManagedProc(ctx, "kind", "load", "docker-image", image, "--name", name).Run()
+}
+
+func (KindProvider) HostAddress(ctx context.Context, name string) (string, error) {
+	return containerAddress(ctx, name+"-control-plane")
+}
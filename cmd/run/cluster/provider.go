@@ -0,0 +1,81 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/argoproj/dev-tools/cmd/run/run"
+)
+
+// Provider provisions the clusters of a particular kind.
+// Its Delete is called from cleanup hooks, so it needs to use run.NewCleanupProc.
+type Provider interface {
+	// Name identifies the provider, as selected by --cluster-provider.
+	Name() string
+	// ContextName is the kubeconfig context of the named cluster.
+	ContextName(name string) string
+	// Create provisions the named cluster, writing its credentials to the kubeconfig file instead of the default one.
+	Create(ctx context.Context, name string, kubeconfig string) error
+	// WriteKubeconfig writes the credentials of the named running cluster to the kubeconfig file.
+	WriteKubeconfig(ctx context.Context, name string, kubeconfig string) error
+	// Delete removes the named cluster, succeeding when it does not exist.
+	Delete(ctx context.Context, name string) error
+	// LoadImage makes the local docker image available to the cluster nodes.
+	LoadImage(ctx context.Context, name string, image string) error
+	// HostAddress is the address the cluster is reachable on from the host.
+	HostAddress(ctx context.Context, name string) (string, error)
+}
+
+// ProviderNames lists the providers NewProvider accepts.
+var ProviderNames = []string{"k3d", "kind", "existing"}
+
+// NewProvider creates the named provider. The kubeContext is used by the existing provider only,
+// empty for the current context, and the spec by the k3d provider only.
+func NewProvider(name string, kubeContext string, spec Spec) (Provider, error) {
+	if name != "k3d" && !spec.IsZero() {
+		return nil, fmt.Errorf("cluster spec is supported by k3d provider only, not %s", name)
+	}
+
+	switch name {
+	case "k3d":
+		return K3dProvider{Spec: spec}, nil
+	case "kind":
+		return KindProvider{}, nil
+	case "existing":
+		return &ExistingProvider{Context: kubeContext}, nil
+	default:
+		return nil, fmt.Errorf("unknown cluster provider %q, expected one of %s", name, strings.Join(ProviderNames, ", "))
+	}
+}
+
+// writeKubeconfig stores the output of the command printing a kubeconfig in the file.
+func writeKubeconfig(ctx context.Context, kubeconfig string, args ...string) error {
+	proc := run.NewManagedProc(ctx, args...)
+	stdout := proc.CaptureStdout()
+	// Contains cluster admin credentials
+	proc.Stdout.Sensitive()
+	if err := proc.Run(); err != nil {
+		return fmt.Errorf("failed getting kubeconfig: %w", err)
+	}
+	if run.IsDryRun() {
+		return nil
+	}
+	return os.WriteFile(kubeconfig, stdout.Bytes(), 0600)
+}
+
+// containerAddress is the IP address of the docker container, as reachable from the host.
+func containerAddress(ctx context.Context, container string) (string, error) {
+	proc := run.NewManagedProc(ctx, "docker", "inspect", "--format", "{{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}", container)
+	stdout := proc.CaptureStdout()
+	if err := proc.Run(); err != nil {
+		return "", err
+	}
+
+	addresses := strings.Fields(stdout.String())
+	if len(addresses) == 0 {
+		return "", fmt.Errorf("no address of container %s", container)
+	}
+	return addresses[0], nil
+}
@@ -0,0 +1,202 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec shapes the nodes of a k3d cluster. Zero values keep the k3d defaults.
+// Node filters of the k3d CLI, like `8080:443@loadbalancer`, are accepted after `@` in ports, k3s args and volumes.
+type Spec struct {
+	// Image of k3s, selecting the Kubernetes version, like rancher/k3s:v1.30.4-k3s1
+	Image   string `yaml:"image"`
+	Servers int    `yaml:"servers"`
+	Agents  int    `yaml:"agents"`
+	// APIPort exposes the Kubernetes API on the [HOST:]PORT, random when empty. IPv6 hosts are enclosed in brackets
+	APIPort string `yaml:"apiPort"`
+	// Ports map HOST:CONTAINER ports, on the load balancer unless filtered otherwise
+	Ports   []string `yaml:"ports"`
+	K3sArgs []string `yaml:"k3sArgs"`
+	// Volumes mount SOURCE:DEST paths
+	Volumes []string `yaml:"volumes"`
+	// RegistriesConfig is the path to the registries.yaml of k3s, configuring mirrors and credentials
+	RegistriesConfig string `yaml:"registriesConfig"`
+}
+
+// LoadSpec reads the Spec from the YAML file, rejecting unknown fields.
+// Relative RegistriesConfig is resolved against the directory of the file.
+func LoadSpec(path string) (Spec, error) {
+	var spec Spec
+	file, err := os.Open(path)
+	if err != nil {
+		return spec, fmt.Errorf("failed reading cluster spec: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&spec); err != nil {
+		return spec, fmt.Errorf("invalid cluster spec %s: %w", path, err)
+	}
+	if spec.RegistriesConfig != "" && !filepath.IsAbs(spec.RegistriesConfig) {
+		spec.RegistriesConfig = filepath.Join(filepath.Dir(path), spec.RegistriesConfig)
+	}
+	return spec, nil
+}
+
+// Merge overrides the spec with the values set in the other, appending the lists.
+func (s Spec) Merge(other Spec) Spec {
+	if other.Image != "" {
+		s.Image = other.Image
+	}
+	if other.Servers != 0 {
+		s.Servers = other.Servers
+	}
+	if other.Agents != 0 {
+		s.Agents = other.Agents
+	}
+	if other.APIPort != "" {
+		s.APIPort = other.APIPort
+	}
+	if other.RegistriesConfig != "" {
+		s.RegistriesConfig = other.RegistriesConfig
+	}
+	s.Ports = slices.Concat(s.Ports, other.Ports)
+	s.K3sArgs = slices.Concat(s.K3sArgs, other.K3sArgs)
+	s.Volumes = slices.Concat(s.Volumes, other.Volumes)
+	return s
+}
+
+// IsZero reports whether the spec sets nothing, keeping all the k3d defaults.
+func (s Spec) IsZero() bool {
+	return s.Image == "" && s.Servers == 0 && s.Agents == 0 && s.APIPort == "" && s.RegistriesConfig == "" &&
+		len(s.Ports) == 0 && len(s.K3sArgs) == 0 && len(s.Volumes) == 0
+}
+
+// k3dConfig is the subset of k3d config file, version v1alpha5, the Spec renders to.
+type k3dConfig struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   k3dMetadata    `yaml:"metadata"`
+	Servers    int            `yaml:"servers,omitempty"`
+	Agents     int            `yaml:"agents,omitempty"`
+	Image      string         `yaml:"image,omitempty"`
+	KubeAPI    *k3dKubeAPI    `yaml:"kubeAPI,omitempty"`
+	Ports      []k3dFiltered  `yaml:"ports,omitempty"`
+	Volumes    []k3dFiltered  `yaml:"volumes,omitempty"`
+	Registries *k3dRegistries `yaml:"registries,omitempty"`
+	Options    k3dOptions     `yaml:"options"`
+}
+
+type k3dMetadata struct {
+	Name string `yaml:"name"`
+}
+
+type k3dKubeAPI struct {
+	HostIP   string `yaml:"hostIP,omitempty"`
+	HostPort string `yaml:"hostPort"`
+}
+
+// k3dFiltered is a port, volume or k3s arg, applied to the nodes matching the filters
+type k3dFiltered struct {
+	Port        string   `yaml:"port,omitempty"`
+	Volume      string   `yaml:"volume,omitempty"`
+	Arg         string   `yaml:"arg,omitempty"`
+	NodeFilters []string `yaml:"nodeFilters,omitempty"`
+}
+
+type k3dRegistries struct {
+	Config string `yaml:"config"`
+}
+
+type k3dOptions struct {
+	K3d struct {
+		Wait bool `yaml:"wait"`
+	} `yaml:"k3d"`
+	K3s struct {
+		ExtraArgs []k3dFiltered `yaml:"extraArgs,omitempty"`
+	} `yaml:"k3s"`
+	Kubeconfig struct {
+		UpdateDefaultKubeconfig bool `yaml:"updateDefaultKubeconfig"`
+		SwitchCurrentContext    bool `yaml:"switchCurrentContext"`
+	} `yaml:"kubeconfig"`
+}
+
+// Render produces the k3d config file creating the named cluster. It waits for the cluster to be ready,
+// and leaves the default kubeconfig untouched.
+func (s Spec) Render(name string) ([]byte, error) {
+	config := k3dConfig{
+		APIVersion: "k3d.io/v1alpha5",
+		Kind:       "Simple",
+		Metadata:   k3dMetadata{Name: name},
+		Servers:    s.Servers,
+		Agents:     s.Agents,
+		Image:      s.Image,
+	}
+	config.Options.K3d.Wait = true
+
+	if s.APIPort != "" {
+		host, port, err := net.SplitHostPort(s.APIPort)
+		if err != nil {
+			if strings.Contains(s.APIPort, ":") {
+				return nil, fmt.Errorf("invalid API port %q, expected [HOST:]PORT: %w", s.APIPort, err)
+			}
+			host, port = "", s.APIPort
+		}
+		config.KubeAPI = &k3dKubeAPI{HostIP: host, HostPort: port}
+	}
+	for _, port := range s.Ports {
+		value, filters := splitNodeFilter(port, "loadbalancer")
+		config.Ports = append(config.Ports, k3dFiltered{Port: value, NodeFilters: filters})
+	}
+	for _, volume := range s.Volumes {
+		value, filters := splitNodeFilter(volume, "")
+		config.Volumes = append(config.Volumes, k3dFiltered{Volume: value, NodeFilters: filters})
+	}
+	// Ingress is not used, and occupies the ports users may want to map
+	k3sArgs := append([]string{"--disable=traefik@server:*"}, s.K3sArgs...)
+	for _, arg := range k3sArgs {
+		value, filters := splitNodeFilter(arg, "")
+		config.Options.K3s.ExtraArgs = append(config.Options.K3s.ExtraArgs, k3dFiltered{Arg: value, NodeFilters: filters})
+	}
+
+	if s.RegistriesConfig != "" {
+		registries, err := os.ReadFile(s.RegistriesConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading registries config: %w", err)
+		}
+		config.Registries = &k3dRegistries{Config: string(registries)}
+	}
+
+	return yaml.Marshal(config)
+}
+
+// Digest identifies the config the spec renders to for the named cluster, to tell whether a cluster still matches it.
+func (s Spec) Digest(name string) (string, error) {
+	config, err := s.Render(name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(config)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// splitNodeFilter separates the value from the node filter after the last `@`, if any.
+func splitNodeFilter(in string, defaultFilter string) (string, []string) {
+	i := strings.LastIndex(in, "@")
+	if i < 0 {
+		if defaultFilter == "" {
+			return in, nil
+		}
+		return in, []string{defaultFilter}
+	}
+	return in[:i], []string{in[i+1:]}
+}
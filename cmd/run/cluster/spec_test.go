@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSpecRender(t *testing.T) {
+	registries := filepath.Join(t.TempDir(), "registries.yaml")
+	if err := os.WriteFile(registries, []byte("mirrors:\n  docker.io:\n    endpoint: [\"http://mirror:5000\"]\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	spec := Spec{
+		Image:            "rancher/k3s:v1.30.4-k3s1",
+		Servers:          1,
+		Agents:           2,
+		APIPort:          "127.0.0.1:6550",
+		Ports:            []string{"8443:443", "30080:30080@agent:0"},
+		K3sArgs:          []string{"--kubelet-arg=max-pods=200@agent:*"},
+		Volumes:          []string{"/tmp/src:/src"},
+		RegistriesConfig: registries,
+	}
+
+	rendered, err := spec.Render("test")
+	if err != nil {
+		t.Fatalf("Render() failed: %v", err)
+	}
+
+	expected := `apiVersion: k3d.io/v1alpha5
+kind: Simple
+metadata:
+    name: test
+servers: 1
+agents: 2
+image: rancher/k3s:v1.30.4-k3s1
+kubeAPI:
+    hostIP: 127.0.0.1
+    hostPort: "6550"
+ports:
+    - port: 8443:443
+      nodeFilters:
+        - loadbalancer
+    - port: 30080:30080
+      nodeFilters:
+        - agent:0
+volumes:
+    - volume: /tmp/src:/src
+registries:
+    config: |
+        mirrors:
+          docker.io:
+            endpoint: ["http://mirror:5000"]
+options:
+    k3d:
+        wait: true
+    k3s:
+        extraArgs:
+            - arg: --disable=traefik
+              nodeFilters:
+                - server:*
+            - arg: --kubelet-arg=max-pods=200
+              nodeFilters:
+                - agent:*
+    kubeconfig:
+        updateDefaultKubeconfig: false
+        switchCurrentContext: false
+`
+	if string(rendered) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, rendered)
+	}
+}
+
+func TestSpecRenderAPIPort(t *testing.T) {
+	for apiPort, expected := range map[string]*k3dKubeAPI{
+		"6550":           {HostPort: "6550"},
+		"0.0.0.0:6550":   {HostIP: "0.0.0.0", HostPort: "6550"},
+		"[::1]:6550":     {HostIP: "::1", HostPort: "6550"},
+		"::1:6550":       nil,
+		"127.0.0.1:6550": {HostIP: "127.0.0.1", HostPort: "6550"},
+	} {
+		rendered, err := Spec{APIPort: apiPort}.Render("test")
+		if expected == nil {
+			if err == nil {
+				t.Errorf("Render() expected to reject API port %q", apiPort)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Render() failed for API port %q: %v", apiPort, err)
+		}
+		var config k3dConfig
+		if err := yaml.Unmarshal(rendered, &config); err != nil {
+			t.Fatal(err)
+		}
+		if config.KubeAPI == nil || *config.KubeAPI != *expected {
+			t.Errorf("expected API %+v for %q, got %+v", expected, apiPort, config.KubeAPI)
+		}
+	}
+}
+
+func TestLoadSpec(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "spec.yaml")
+	if err := os.WriteFile(path, []byte("image: rancher/k3s:v1.29.8-k3s1\nagents: 1\nports: [\"8080:80\"]\nregistriesConfig: registries.yaml\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := LoadSpec(path)
+	if err != nil {
+		t.Fatalf("LoadSpec() failed: %v", err)
+	}
+	spec = spec.Merge(Spec{Agents: 3, Ports: []string{"8443:443"}})
+
+	if spec.Image != "rancher/k3s:v1.29.8-k3s1" || spec.Agents != 3 {
+		t.Errorf("expected flags to override the file: %+v", spec)
+	}
+	if !slices.Equal(spec.Ports, []string{"8080:80", "8443:443"}) {
+		t.Errorf("expected ports of both the file and flags: %q", spec.Ports)
+	}
+	if spec.RegistriesConfig != filepath.Join(dir, "registries.yaml") {
+		t.Errorf("expected registries config relative to the spec file: %q", spec.RegistriesConfig)
+	}
+
+	if err := os.WriteFile(path, []byte("agent: 1\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSpec(path); err == nil {
+		t.Errorf("LoadSpec() expected to reject unknown field")
+	}
+}
+
+func TestNewProviderSpec(t *testing.T) {
+	provider, err := NewProvider("k3d", "", Spec{Agents: 1})
+	if err != nil {
+		t.Fatalf("NewProvider() failed: %v", err)
+	}
+	if provider.(K3dProvider).Spec.Agents != 1 {
+		t.Errorf("expected spec passed to k3d: %+v", provider)
+	}
+
+	if _, err := NewProvider("kind", "", Spec{Agents: 1}); err == nil {
+		t.Errorf("NewProvider() expected to reject spec for kind")
+	}
+	// Empty lists set nothing
+	if _, err := NewProvider("kind", "", Spec{Ports: []string{}}); err != nil {
+		t.Errorf("NewProvider() expected to accept empty spec for kind: %v", err)
+	}
+}
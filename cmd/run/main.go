@@ -1,30 +1,129 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/argoproj/dev-tools/cmd/run/project"
+	"github.com/argoproj/dev-tools/cmd/run/run"
 	"github.com/spf13/cobra"
 )
 
 func main() {
-	if err := newRootCommand().Execute(); err != nil {
-		_, _ = fmt.Fprintln(os.Stderr, err)
-		_, _ = fmt.Fprintf(os.Stderr, "%T / %T\n", err, errors.Unwrap(err))
+	ctx, cancel := run.WithTaskTracker(context.Background())
+	err := newRootCommand().ExecuteContext(ctx)
+	// Cancelled sooner only by a signal, see run.HandleSignals
+	interrupted := ctx.Err() != nil
+	cancel()
+	if interrupted {
+		// Bounded by the shutdown timeout of the signal handler
+		run.WaitForTasks(ctx)
+	}
+	run.RunCleanups()
+	run.ReportResources()
+	if interrupted {
+		os.Exit(run.InterruptedExitCode)
+	}
+	if err != nil {
+		// The report describes the failed command in full, including the error
+		var procErr *run.ProcessError
+		if errors.As(err, &procErr) {
+			_, _ = fmt.Fprintln(os.Stderr, procErr.Report())
+		} else {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+		}
 		os.Exit(1)
 	}
 }
 
 func newRootCommand() *cobra.Command {
+	opts := rootOpts{}
 	cmd := &cobra.Command{
 		Use:   "run",
 		Short: "Run dev-tools workflows",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.init(cmd.Context())
+		},
 	}
 
+	opts.registerFlags(cmd)
+
 	cmd.AddCommand(project.NewCDCommand())
 	cmd.AddCommand(project.NewRolloutsCommand())
 
 	return cmd
 }
+
+type rootOpts struct {
+	dryRun          bool
+	record          string
+	replay          string
+	logDir          string
+	logMaxSize      int64
+	statusInterval  time.Duration
+	gracePeriod     time.Duration
+	reportJSON      string
+	eventsFile      string
+	shutdownTimeout time.Duration
+}
+
+func (opts *rootOpts) registerFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(&opts.dryRun, "dry-run", false, "Print the commands of the workflow instead of running them")
+	cmd.PersistentFlags().StringVar(&opts.record, "record", "", "Record all executed commands and their outputs to a cassette file")
+	cmd.PersistentFlags().StringVar(&opts.replay, "replay", "", "Replay commands from a cassette file instead of running them")
+	cmd.MarkFlagsMutuallyExclusive("dry-run", "record", "replay")
+	cmd.PersistentFlags().StringVar(&opts.logDir, "log-dir", filepath.Join(os.TempDir(), "argo-dev-tools"), "Directory to write per-run process logs to, empty to disable")
+	cmd.PersistentFlags().Int64Var(&opts.logMaxSize, "log-max-size", 10, "Size in MiB after which process log files are rotated")
+	cmd.PersistentFlags().DurationVar(&opts.gracePeriod, "grace-period", run.DefaultGracePeriod, "Time for interrupted processes to terminate before they are killed")
+	cmd.PersistentFlags().DurationVar(&opts.shutdownTimeout, "shutdown-timeout", run.DefaultShutdownTimeout, "Time to wait for the workflow to clean up after interrupt, before the exit is forced, 0 to wait indefinitely")
+	cmd.PersistentFlags().StringVar(&opts.reportJSON, "report-json", "", "Write resources consumed by the processes as JSON to the file at exit")
+	cmd.PersistentFlags().StringVar(&opts.eventsFile, "events-file", "", "Write structured lifecycle events to the file as JSON lines")
+	cmd.PersistentFlags().DurationVar(&opts.statusInterval, "status-interval", 30*time.Second, "Interval to report running processes in, 0 to disable")
+}
+
+func (opts *rootOpts) init(ctx context.Context) error {
+	run.HandleSignals(ctx, opts.shutdownTimeout)
+	run.DefaultGracePeriod = opts.gracePeriod
+	run.SetResourceReportJSON(opts.reportJSON)
+	if opts.eventsFile != "" {
+		if err := run.SetEventsFile(ctx, opts.eventsFile); err != nil {
+			return err
+		}
+	}
+	if opts.dryRun {
+		run.SetExecutor(run.DryRunExecutor{})
+	}
+	if opts.record != "" {
+		recorder, err := run.NewRecordingExecutor(ctx, run.OsExecutor{}, opts.record)
+		if err != nil {
+			return err
+		}
+		run.SetExecutor(recorder)
+	}
+	if opts.replay != "" {
+		replayer, err := run.NewReplayExecutor(opts.replay)
+		if err != nil {
+			return err
+		}
+		run.SetExecutor(replayer)
+	}
+
+	// Nothing is run to be logged under dry-run
+	if opts.logDir != "" && !opts.dryRun {
+		dir, err := run.InitLogDir(opts.logDir, opts.logMaxSize*1024*1024)
+		if err != nil {
+			return err
+		}
+		run.Out(os.Stderr, "Writing process logs to %s", dir)
+	}
+
+	if opts.statusInterval > 0 {
+		run.StartStatusReporter(ctx, opts.statusInterval)
+	}
+
+	return nil
+}
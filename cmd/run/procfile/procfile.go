@@ -0,0 +1,190 @@
+package procfile
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/argoproj/dev-tools/cmd/run/run"
+	"github.com/fatih/color"
+)
+
+var (
+	entryPattern = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+	prefixColors = []*color.Color{
+		color.New(color.FgCyan),
+		color.New(color.FgMagenta),
+		color.New(color.FgBlue),
+		color.New(color.FgGreen),
+		color.New(color.FgHiCyan),
+		color.New(color.FgHiMagenta),
+		color.New(color.FgHiBlue),
+		color.New(color.FgHiGreen),
+	}
+)
+
+// Entry is a named command of a Procfile.
+type Entry struct {
+	Name    string
+	Command string
+}
+
+// Parse reads Procfile entries in the order they are declared. Blank lines and comments are skipped.
+func Parse(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening Procfile %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	names := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		match := entryPattern.FindStringSubmatch(text)
+		if match == nil {
+			return nil, fmt.Errorf("malformed Procfile entry %s:%d: %s", path, line, text)
+		}
+		if names[match[1]] {
+			return nil, fmt.Errorf("duplicate Procfile entry %s:%d: %s", path, line, match[1])
+		}
+		names[match[1]] = true
+		entries = append(entries, Entry{Name: match[1], Command: match[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading Procfile %s: %w", path, err)
+	}
+
+	return entries, nil
+}
+
+// ParseEnv reads KEY=VALUE pairs from an env file, expanding references to the environment and previously declared keys.
+// Missing file is not an error, as the file is optional.
+func ParseEnv(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error opening env file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	declared := map[string]string{}
+	lookup := func(key string) string {
+		if value, ok := declared[key]; ok {
+			return value
+		}
+		return os.Getenv(key)
+	}
+
+	var env []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		if !found {
+			return nil, fmt.Errorf("malformed env file entry %s:%d: %s", path, line, text)
+		}
+		key = strings.TrimSpace(key)
+		value = os.Expand(strings.Trim(strings.TrimSpace(value), `"'`), lookup)
+		declared[key] = value
+		env = append(env, key+"="+value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading env file %s: %w", path, err)
+	}
+
+	return env, nil
+}
+
+// Runner runs every Procfile entry as its own ManagedProc, with a colored name prefix and log file.
+// Stopping, or failure, of any of the entries stops the rest, the same way goreman does.
+type Runner struct {
+	names []string
+	procs map[string]*run.ManagedProc
+}
+
+// NewRunner prepares processes for the entries, that can be further customized through Proc before Run.
+// Commands are interpreted by shell, with env added to their environment.
+func NewRunner(ctx context.Context, entries []Entry, env []string, transformer run.LineSink) *Runner {
+	width := 0
+	for _, entry := range entries {
+		width = max(width, len(entry.Name))
+	}
+
+	r := &Runner{procs: map[string]*run.ManagedProc{}}
+	for i, entry := range entries {
+		mp := run.NewManagedProc(ctx, "sh", "-c", entry.Command)
+		mp.Label(entry.Name)
+		for _, kv := range env {
+			key, value, _ := strings.Cut(kv, "=")
+			mp.AddEnv(key, value)
+		}
+
+		prefix := prefixColors[i%len(prefixColors)].Sprintf("%-*s | ", width, entry.Name)
+		mp.Stdout.Transform(transformer)
+		mp.Stdout.Transform(prefixed(prefix))
+		mp.Stderr.Transform(transformer)
+		mp.Stderr.Transform(prefixed(prefix))
+
+		r.names = append(r.names, entry.Name)
+		r.procs[entry.Name] = mp
+	}
+	return r
+}
+
+// Proc returns the process of the named entry, or nil if there is no such entry.
+func (r *Runner) Proc(name string) *run.ManagedProc {
+	return r.procs[name]
+}
+
+// Run starts all the processes and waits for them to complete.
+func (r *Runner) Run() error {
+	var wg sync.WaitGroup
+	var stopping atomic.Bool
+	errs := make([]error, len(r.names))
+	for i, name := range r.names {
+		mp := r.procs[name]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Failures of the processes stopped by the runner are not interesting
+			if err := mp.Run(); err != nil && !stopping.Load() {
+				errs[i] = fmt.Errorf("%s %w", name, err)
+			}
+
+			// Do not leave the rest running without this one
+			stopping.Store(true)
+			for _, other := range r.procs {
+				other.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func prefixed(prefix string) run.LineSink {
+	return func(in string) *string {
+		out := prefix + in
+		return &out
+	}
+}
@@ -0,0 +1,82 @@
+package procfile
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	path := t.TempDir() + "/file"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParse(t *testing.T) {
+	path := writeFile(t, `# components
+controller: sh -c "echo controller"
+
+api-server: [ "$BIN_MODE" = 'true' ] && echo bin || echo go
+`)
+
+	entries, err := Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Entry{
+		{"controller", `sh -c "echo controller"`},
+		{"api-server", `[ "$BIN_MODE" = 'true' ] && echo bin || echo go`},
+	}
+	if !slices.Equal(entries, expected) {
+		t.Errorf("expected %v, got %v", expected, entries)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, content := range []string{"no command here", "a: echo\na: echo again"} {
+		if _, err := Parse(writeFile(t, content)); err == nil {
+			t.Errorf("expected %q to be rejected", content)
+		}
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	t.Setenv("PROCFILE_TEST_HOME", "/home/test")
+	path := writeFile(t, `
+BASE=$PROCFILE_TEST_HOME/argo
+export DATA="${BASE}/data"
+`)
+
+	env, err := ParseEnv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"BASE=/home/test/argo", "DATA=/home/test/argo/data"}
+	if !slices.Equal(env, expected) {
+		t.Errorf("expected %v, got %v", expected, env)
+	}
+
+	if env, err := ParseEnv(t.TempDir() + "/missing"); err != nil || env != nil {
+		t.Errorf("missing env file expected to be ignored, got %v, %v", env, err)
+	}
+}
+
+func TestRunnerStopsAllOnFailure(t *testing.T) {
+	runner := NewRunner(t.Context(), []Entry{
+		{"server", "sleep 10"},
+		{"crashing", `echo "$GREETING"; exit 3`},
+	}, []string{"GREETING=hello"}, nil)
+	runner.Proc("server").Timeout = 5 * time.Second
+
+	start := time.Now()
+	err := runner.Run()
+	if err == nil {
+		t.Fatal("expected the crashing entry to fail the runner")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("expected server stopped after crash, took %s", elapsed)
+	}
+}
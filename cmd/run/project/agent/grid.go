@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/argoproj/dev-tools/cmd/run/cluster"
@@ -19,9 +20,18 @@ type Grid struct {
 	ControlPlane *cluster.KubeCluster
 	Managed      *cluster.KubeCluster
 	Autonomous   *cluster.KubeCluster
+	// localImage replaces the released image in the deployed manifests, nil to deploy the released images
+	localImage *cluster.LocalImage
+	// cleanup closes the clusters on exit, unless the Grid is Close()d sooner
+	cleanup *run.CleanupHook
 }
 
+// Close deletes the clusters, unless they have been already.
 func (g *Grid) Close() {
+	_ = g.cleanup.Run()
+}
+
+func (g *Grid) teardown(ctx context.Context) error {
 	if g.Managed != nil {
 		g.Managed.Close()
 	}
@@ -31,12 +41,16 @@ func (g *Grid) Close() {
 	if g.ControlPlane != nil {
 		g.ControlPlane.Close()
 	}
+	return nil
 }
 
 // NewGrid start all the clusters needed for the grid
 // It returns either all the clusters up, so users is responsible to Close() after, or no cluster up at all
-func NewGrid() (*Grid, error) {
-	err := run.CheckDocker()
+func NewGrid(ctx context.Context, provider cluster.Provider) (*Grid, error) {
+	if _, existing := provider.(*cluster.ExistingProvider); existing {
+		return nil, fmt.Errorf("agent grid needs clusters of its own, %s provider cannot create them", provider.Name())
+	}
+	err := run.CheckDocker(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -59,7 +73,7 @@ func NewGrid() (*Grid, error) {
 				wg.Done()
 			}()
 
-			clstr, err := cluster.NewK3dCluster(clusterName)
+			clstr, err := cluster.NewCluster(ctx, provider, clusterName, cluster.Options{})
 			if err != nil {
 				errorChan <- err
 				return
@@ -69,13 +83,12 @@ func NewGrid() (*Grid, error) {
 				return
 			}
 			setter(clstr)
-			err = clstr.CreateNs("argocd")
+			err = clstr.CreateNs(ctx, "argocd")
 			if err != nil {
 				errorChan <- err
 				return
 			}
-			// TODO: prone to race condition
-			err = clstr.UseNs("argocd")
+			err = clstr.UseNs(ctx, "argocd")
 			if err != nil {
 				errorChan <- err
 				return
@@ -84,12 +97,17 @@ func NewGrid() (*Grid, error) {
 	}
 
 	wg.Wait()
+	// Delete the clusters in the grid order, rather than the order they happen to be created in
+	acg.cleanup = run.OnCleanup(ctx, "agent grid", 3*run.DefaultCleanupTimeout, acg.teardown)
 
 	// ControlPlane needs to have NS with name matching agent name
-	for clusterName, _ := range clusters {
-		err = acg.ControlPlane.CreateNs(clusterName)
-		if err != nil {
-			errorChan <- err
+	// Skipped when some cluster failed, the ControlPlane might not even exist
+	if len(errorChan) == 0 {
+		for clusterName, _ := range clusters {
+			err = acg.ControlPlane.CreateNs(ctx, clusterName)
+			if err != nil {
+				errorChan <- err
+			}
 		}
 	}
 
@@ -104,19 +122,21 @@ func NewGrid() (*Grid, error) {
 	return acg, nil
 }
 
-func (g *Grid) PrintDetails(verbose bool) {
+func (g *Grid) PrintDetails(ctx context.Context, verbose bool) {
 	run.Out(os.Stderr, "Agent grid details:")
 
 	header := func(c *cluster.KubeCluster) {
 		run.Out(os.Stderr, "===")
 		run.Out(os.Stderr, c.Name+":")
 		run.Out(os.Stderr, "  context: "+c.ContextName)
+		run.Out(os.Stderr, "  kubeconfig: "+c.Kubeconfig)
 		if verbose {
-			err := c.KubectlProc("get", "pod,service,secret,deployment", "--all-namespaces").Run()
+			err := c.KubectlProc(ctx, "get", "pod,service,secret,deployment", "--all-namespaces").Run()
 			if err != nil {
 				run.Out(os.Stderr, "  error: "+err.Error())
 			}
 			proc := c.KubectlProc(
+				ctx,
 				"get", "pods", "--all-namespaces", "--no-headers", "--field-selector=status.phase!=Running",
 				"-o=custom-columns='NAMESPACE:.metadata.namespace,NAME:.metadata.name,STATUS:.status.phase'",
 			)
@@ -127,7 +147,7 @@ func (g *Grid) PrintDetails(verbose bool) {
 			for podSpec := range strings.Lines(stdout.String()) {
 				run.Out(os.Stderr, podSpec+"\n")
 				split := strings.Fields(podSpec)
-				err = c.KubectlProc("describe", "-n", split[0], "pod", split[1]).Run()
+				err = c.KubectlProc(ctx, "describe", "-n", split[0], "pod", split[1]).Run()
 				if err != nil {
 					run.Out(os.Stderr, "  error: "+err.Error())
 				}
@@ -140,13 +160,24 @@ func (g *Grid) PrintDetails(verbose bool) {
 	header(g.Managed)
 }
 
-func (g *Grid) DeployControlPlane(manifests *Manifests) error {
-	err := doubleApply(g.ControlPlane, "apply", "-k", manifests.Path("/control-plane/"))
+// UseLocalImage imports the image into all the clusters, to be deployed in place of the released one.
+func (g *Grid) UseLocalImage(ctx context.Context, image cluster.LocalImage) error {
+	for _, c := range []*cluster.KubeCluster{g.ControlPlane, g.Managed, g.Autonomous} {
+		if err := c.ImportImage(ctx, image.Name); err != nil {
+			return err
+		}
+	}
+	g.localImage = &image
+	return nil
+}
+
+func (g *Grid) DeployControlPlane(ctx context.Context, manifests *Manifests) error {
+	err := g.doubleApply(ctx, g.ControlPlane, manifests.Path("/control-plane/"))
 	if err != nil {
 		return err
 	}
 
-	repoServerHostname, err := g.waitForRepoServerHostname()
+	repoServerHostname, err := g.waitForRepoServerHostname(ctx)
 	if err != nil {
 		return err
 	}
@@ -155,13 +186,13 @@ func (g *Grid) DeployControlPlane(manifests *Manifests) error {
 	return nil
 }
 
-func (g *Grid) DeployAgents(manifests *Manifests) error {
-	err := doubleApply(g.Managed, "apply", "-k", manifests.Path("agent-managed"))
+func (g *Grid) DeployAgents(ctx context.Context, manifests *Manifests) error {
+	err := g.doubleApply(ctx, g.Managed, manifests.Path("agent-managed"))
 	if err != nil {
 		return err
 	}
 
-	err = doubleApply(g.Autonomous, "apply", "-k", manifests.Path("agent-autonomous"))
+	err = g.doubleApply(ctx, g.Autonomous, manifests.Path("agent-autonomous"))
 	if err != nil {
 		return err
 	}
@@ -169,32 +200,45 @@ func (g *Grid) DeployAgents(manifests *Manifests) error {
 	return nil
 }
 
-func doubleApply(c *cluster.KubeCluster, args ...string) error {
-	// Run 'kubectl apply' twice, to avoid the following error that occurs during the first invocation:
-	// - 'error: resource mapping not found for name: "default" namespace: "" from "(...)": no matches for kind "AppProject" in version "argoproj.io/v1alpha1"'
-	_ = c.KubectlProc(args...).Run()
-	return c.KubectlProc(args...).Run()
-}
-
-func (g *Grid) waitForRepoServerHostname() (string, error) {
-	for i := 0; i < 15; i++ {
-		repoServerHostname, err := g.getRepoServerHostname()
-		if err == nil {
-			return repoServerHostname, nil
-		}
-		if !errors.Is(err, errFailedWaitingForRepoServerHostname) {
-			return "", err
-		}
+func (g *Grid) doubleApply(ctx context.Context, c *cluster.KubeCluster, kustomization string) error {
+	if g.localImage == nil {
+		// Run 'kubectl apply' twice, to avoid the following error that occurs during the first invocation:
+		// - 'error: resource mapping not found for name: "default" namespace: "" from "(...)": no matches for kind "AppProject" in version "argoproj.io/v1alpha1"'
+		_ = c.KubectlProc(ctx, "apply", "-k", kustomization).Run()
+		return c.KubectlProc(ctx, "apply", "-k", kustomization).Run()
+	}
 
-		run.Out(os.Stderr, "Waiting for repo server hostname...")
-		time.Sleep(2 * time.Second)
+	// Image references are known only once the kustomization is rendered
+	proc := c.KubectlProc(ctx, "kustomize", kustomization)
+	stdout := proc.CaptureStdout()
+	if err := proc.Run(); err != nil {
+		return err
 	}
+	rendered := g.localImage.Rewrite(stdout.String())
 
-	return "", errFailedWaitingForRepoServerHostname
+	apply := c.KubectlProc(ctx, "apply", "-f", "-")
+	apply.Stdin(strings.NewReader(rendered))
+	_ = apply.Run()
+	apply = c.KubectlProc(ctx, "apply", "-f", "-")
+	apply.Stdin(strings.NewReader(rendered))
+	return apply.Run()
+}
+
+func (g *Grid) waitForRepoServerHostname(ctx context.Context) (string, error) {
+	var hostname string
+	poller := run.Poller{Description: "repo server hostname", Timeout: time.Minute}
+	err := poller.Poll(ctx, func() (err error) {
+		hostname, err = g.getRepoServerHostname(ctx)
+		if err != nil && !errors.Is(err, errFailedWaitingForRepoServerHostname) {
+			return run.StopPolling(err)
+		}
+		return err
+	})
+	return hostname, err
 }
 
-func (g *Grid) getRepoServerHostname() (string, error) {
-	json, err := g.ControlPlane.KubectlGetJson("svc", "argocd-repo-server")
+func (g *Grid) getRepoServerHostname(ctx context.Context) (string, error) {
+	json, err := g.ControlPlane.KubectlGetJson(ctx, "svc", "argocd-repo-server")
 	if err != nil {
 		return "", err
 	}
@@ -214,22 +258,22 @@ func (g *Grid) getRepoServerHostname() (string, error) {
 	return hostname, nil
 }
 
-func (g *Grid) WaitForAllPodsRunning() error {
+func (g *Grid) WaitForAllPodsRunning(ctx context.Context) error {
 	var wg sync.WaitGroup
 	wg.Add(3)
 
 	var err error
 	go func() {
 		defer wg.Done()
-		err = g.ControlPlane.WaitForAllPodsRunning()
+		err = g.ControlPlane.WaitForAllPodsRunning(ctx)
 	}()
 	go func() {
 		defer wg.Done()
-		err = g.Managed.WaitForAllPodsRunning()
+		err = g.Managed.WaitForAllPodsRunning(ctx)
 	}()
 	go func() {
 		defer wg.Done()
-		err = g.Autonomous.WaitForAllPodsRunning()
+		err = g.Autonomous.WaitForAllPodsRunning(ctx)
 	}()
 
 	wg.Wait()
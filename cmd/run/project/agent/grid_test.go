@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"os"
+	"testing"
+
+	"github.com/argoproj/dev-tools/cmd/run/cluster"
+	"github.com/argoproj/dev-tools/cmd/run/run"
+)
+
+func TestNewGrid(t *testing.T) {
+	fake := run.UseFakeExecutor(t)
+	fake.On(`.*`, run.FakeResponse{})
+
+	grid, err := NewGrid(t.Context(), cluster.K3dProvider{})
+	if err != nil {
+		t.Fatalf("NewGrid() failed: %v", err)
+	}
+	if grid.ControlPlane.Name != "argocd-agent-control-plane" ||
+		grid.Managed.Name != "argocd-agent-managed" ||
+		grid.Autonomous.Name != "argocd-agent-autonomous" {
+		t.Errorf("unexpected clusters: %v %v %v", grid.ControlPlane, grid.Managed, grid.Autonomous)
+	}
+
+	for _, name := range []string{"argocd-agent-control-plane", "argocd-agent-managed", "argocd-agent-autonomous"} {
+		if fake.Count(`^k3d cluster create .* `+name+`$`) != 1 {
+			t.Errorf("cluster %s expected to be created once: %q", name, fake.Invocations())
+		}
+		if fake.Count(`^kubectl --context k3d-`+name+` create namespace argocd$`) != 1 {
+			t.Errorf("cluster %s expected to have argocd namespace: %q", name, fake.Invocations())
+		}
+		if fake.Count(`^kubectl --context k3d-argocd-agent-control-plane create namespace `+name+`$`) != 1 {
+			t.Errorf("control plane expected to have %s namespace: %q", name, fake.Invocations())
+		}
+	}
+
+	grid.Close()
+	if count := fake.Count(`^k3d cluster delete `); count != 6 {
+		t.Errorf("expected leftovers and clusters deleted, got %d deletions", count)
+	}
+}
+
+func TestNewGridFailedCluster(t *testing.T) {
+	fake := run.UseFakeExecutor(t)
+	fake.On(`^k3d cluster create .* argocd-agent-control-plane$`, run.FakeResponse{ExitCode: 1})
+	fake.On(`.*`, run.FakeResponse{})
+
+	grid, err := NewGrid(t.Context(), cluster.K3dProvider{})
+	if err == nil {
+		t.Fatalf("NewGrid() expected to fail, got %v", grid)
+	}
+
+	// All clusters cleaned up: leftovers before creation, the failed one, and the 2 created on grid close
+	if count := fake.Count(`^k3d cluster delete `); count != 6 {
+		t.Errorf("expected all clusters deleted, got %d deletions: %q", count, fake.Invocations())
+	}
+}
+
+func TestNewGridExistingCluster(t *testing.T) {
+	fake := run.UseFakeExecutor(t)
+	fake.On(`.*`, run.FakeResponse{})
+
+	if _, err := NewGrid(t.Context(), &cluster.ExistingProvider{}); err == nil {
+		t.Fatalf("NewGrid() expected to fail with existing cluster")
+	}
+	if len(fake.Invocations()) != 0 {
+		t.Errorf("expected no commands run: %q", fake.Invocations())
+	}
+}
+
+func TestGridLocalImage(t *testing.T) {
+	manifests := &Manifests{tempDir: t.TempDir()}
+	for _, dir := range []string{"agent-managed", "agent-autonomous"} {
+		if err := os.Mkdir(manifests.NewPath(dir), 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake := run.UseFakeExecutor(t)
+	fake.On(`^docker image inspect `, run.FakeResponse{Stdout: "sha256:0123abcd\n"})
+	fake.On(` kustomize `, run.FakeResponse{Stdout: "image: quay.io/argoproj/argocd:v2.14.0\nimagePullPolicy: Always\n"})
+	fake.On(`.*`, run.FakeResponse{})
+
+	grid := &Grid{}
+	for _, c := range []**cluster.KubeCluster{&grid.ControlPlane, &grid.Managed, &grid.Autonomous} {
+		*c = &cluster.KubeCluster{Name: "test", ContextName: "k3d-test", Namespace: "argocd", Provider: cluster.K3dProvider{}}
+	}
+	grid.ControlPlane.Name, grid.Managed.Name, grid.Autonomous.Name = "cp", "managed", "autonomous"
+	image := cluster.LocalImage{Name: "argo-dev-tools/argocd:local", Replaces: "quay.io/argoproj/argocd"}
+	if err := grid.UseLocalImage(t.Context(), image); err != nil {
+		t.Fatalf("UseLocalImage() failed: %v", err)
+	}
+	if err := grid.DeployAgents(t.Context(), manifests); err != nil {
+		t.Fatalf("DeployAgents() failed: %v", err)
+	}
+
+	for _, name := range []string{"cp", "managed", "autonomous"} {
+		if fake.Count(`^k3d image import argo-dev-tools/argocd:local --cluster `+name+`$`) != 1 {
+			t.Errorf("expected image imported to %s: %q", name, fake.Invocations())
+		}
+	}
+	if fake.Count(` apply -k `) != 0 || fake.Count(` kustomize .*/agent-(managed|autonomous)$`) != 2 {
+		t.Errorf("expected kustomizations rendered instead of applied directly: %q", fake.Invocations())
+	}
+	applied := fake.Stdins(` apply -f -$`)
+	if len(applied) != 4 {
+		t.Fatalf("expected rendered manifests applied twice per cluster, got %q", fake.Invocations())
+	}
+	for _, manifest := range applied {
+		if manifest != "image: argo-dev-tools/argocd:local\nimagePullPolicy: IfNotPresent\n" {
+			t.Errorf("expected local image in applied manifest, got %q", manifest)
+		}
+	}
+}
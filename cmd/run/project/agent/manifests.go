@@ -1,12 +1,12 @@
 package agent
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"github.com/argoproj/dev-tools/cmd/run/run"
 	"github.com/exponent-io/jsonpath"
 	"github.com/sethvargo/go-password/password"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -15,6 +15,8 @@ import (
 
 type Manifests struct {
 	tempDir string
+	// cleanup removes tempDir on exit, unless it is Close()d sooner
+	cleanup *run.CleanupHook
 }
 
 type ManifestData struct {
@@ -25,21 +27,25 @@ type ManifestData struct {
 	PwdAutonomous   string
 }
 
-func NewManifests(from string) (*Manifests, error) {
-	tempDir, err := os.MkdirTemp("", "argo-dev-tools-*")
+func NewManifests(ctx context.Context, from string) (*Manifests, error) {
+	tempDir, err := run.MkdirTemp("argo-dev-tools-*")
 	if err != nil {
 		return nil, err
 	}
 	m := &Manifests{tempDir: tempDir}
+	m.cleanup = run.OnCleanup(ctx, "temp dir "+tempDir, 0, func(ctx context.Context) error {
+		return os.RemoveAll(tempDir)
+	})
 
 	// Much shorter than GO impl
-	err = run.NewManagedProc("rsync", "-a", from, tempDir).Run()
+	err = run.NewManagedProc(ctx, "rsync", "-a", from, tempDir).Run()
 	if err != nil {
 		m.Close()
 		return nil, err
 	}
 
 	proc := run.NewManagedProc(
+		ctx,
 		"git", "clone",
 		"--depth=1", "--branch=stable",
 		"--config=advice.detachedHead=false", "--quiet", // no warnings to clutter output
@@ -47,17 +53,16 @@ func NewManifests(from string) (*Manifests, error) {
 	)
 	proc.Dir(m.tempDir)
 	if err = proc.Run(); err != nil {
+		m.Close()
 		return nil, err
 	}
 
 	return m, nil
 }
 
+// Close removes the temp dir, unless it has been already.
 func (m *Manifests) Close() {
-	err := os.RemoveAll(m.tempDir)
-	if err != nil {
-		log.Printf("Error removing destination directory %s: %v", m.tempDir, err)
-	}
+	_ = m.cleanup.Run()
 }
 
 func (m *Manifests) Path(relative string) string {
@@ -77,11 +82,11 @@ func (m *Manifests) checkExists(absolute string) error {
 	return err
 }
 
-func (m *Manifests) Replace(path string, search string, replace string) error {
+func (m *Manifests) Replace(ctx context.Context, path string, search string, replace string) error {
 	if err := m.checkExists(path); err != nil {
 		return err
 	}
-	return run.NewManagedProc("sed", "-i.bak", "s~"+search+"~"+replace+"~g", path).Run()
+	return run.NewManagedProc(ctx, "sed", "-i.bak", "s~"+search+"~"+replace+"~g", path).Run()
 }
 
 func (m *Manifests) Append(path string, content string) error {
@@ -110,20 +115,20 @@ func (m *Manifests) Create(path string, format string, args ...interface{}) erro
 	}
 	defer file.Close()
 
-	_, err = fmt.Fprintf(file, format, args)
+	_, err = fmt.Fprintf(file, format, args...)
 	return err
 }
 
-func (m *Manifests) InjectValues(data *ManifestData) error {
+func (m *Manifests) InjectValues(ctx context.Context, data *ManifestData) error {
 	var err error
-	data.ArgocdRelease, err = m.argocdRelease()
+	data.ArgocdRelease, err = m.argocdRelease(ctx)
 
-	err = m.injectLb(data)
+	err = m.injectLb(ctx, data)
 	if err != nil {
 		return err
 	}
 
-	err = m.injectReleaseTag(data, err)
+	err = m.injectReleaseTag(ctx, data, err)
 	if err != nil {
 		return err
 	}
@@ -137,6 +142,10 @@ func (m *Manifests) InjectValues(data *ManifestData) error {
 }
 
 func (m *Manifests) injectArgoSecrets(data *ManifestData, err error) error {
+	run.RegisterSecret(data.PwdManaged)
+	run.RegisterSecret(data.PwdAutonomous)
+	run.RegisterSecret(data.PwdControlPlane)
+
 	err = m.Append(m.Path("agent-managed/argocd-secret.yaml"), fmt.Sprintf(
 		"data:\n    server.secretkey: %s\n",
 		data.PwdManaged,
@@ -163,14 +172,14 @@ func (m *Manifests) injectArgoSecrets(data *ManifestData, err error) error {
 	return nil
 }
 
-func (m *Manifests) injectReleaseTag(data *ManifestData, err error) error {
+func (m *Manifests) injectReleaseTag(ctx context.Context, data *ManifestData, err error) error {
 	kustomizations := []string{
 		"control-plane/kustomization.yaml",
 		"agent-autonomous/kustomization.yaml",
 		"agent-managed/kustomization.yaml",
 	}
 	for _, kustomization := range kustomizations {
-		err = m.Replace(m.Path(kustomization), "LatestReleaseTag", data.ArgocdRelease)
+		err = m.Replace(ctx, m.Path(kustomization), "LatestReleaseTag", data.ArgocdRelease)
 		if err != nil {
 			return err
 		}
@@ -178,8 +187,9 @@ func (m *Manifests) injectReleaseTag(data *ManifestData, err error) error {
 	return nil
 }
 
-func (m *Manifests) injectLb(data *ManifestData) error {
+func (m *Manifests) injectLb(ctx context.Context, data *ManifestData) error {
 	proc := run.NewManagedProc(
+		ctx,
 		"sed", "-i.bak", "-e", "/loadBalancerIP/s/192\\.168\\.56./"+data.LbNetPrefix+"/",
 		m.Path("control-plane/redis-service.yaml"),
 		m.Path("control-plane/repo-server-service.yaml"),
@@ -190,21 +200,25 @@ func (m *Manifests) injectLb(data *ManifestData) error {
 	return proc.Run()
 }
 
-func (m *Manifests) InjectManagedAddresses(redis string, repoServer string) error {
+func (m *Manifests) InjectManagedAddresses(ctx context.Context, redis string, repoServer string) error {
 	file := m.Path("agent-managed/argocd-cmd-params-cm.yaml")
-	err := m.Replace(file, "repo-server-address", repoServer)
+	err := m.Replace(ctx, file, "repo-server-address", repoServer)
 	if err != nil {
 		return err
 	}
-	err = m.Replace(file, "redis-server-address", redis)
+	err = m.Replace(ctx, file, "redis-server-address", redis)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m *Manifests) argocdRelease() (string, error) {
-	resp, err := http.Get("https://api.github.com/repos/argoproj/argo-cd/releases/latest")
+func (m *Manifests) argocdRelease(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.github.com/repos/argoproj/argo-cd/releases/latest", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -231,7 +245,7 @@ func (m *Manifests) argocdRelease() (string, error) {
 	return tagName, nil
 }
 
-func (m *Manifests) GenerateAccountSecrets() error {
+func (m *Manifests) GenerateAccountSecrets(ctx context.Context) error {
 	err := os.Mkdir(m.NewPath("creds"), os.FileMode(0750))
 	if err != nil {
 		return err
@@ -248,7 +262,7 @@ func (m *Manifests) GenerateAccountSecrets() error {
 	accounts := []string{"agent-managed", "agent-autonomous"}
 	for _, account := range accounts {
 		pwd := password.MustGenerate(64, 10, 10, false, true)
-		proc := run.NewManagedProc("htpasswd", "-b", "-B", controlPlane, account, pwd)
+		proc := run.NewManagedProc(ctx, "htpasswd", "-b", "-B", controlPlane, account, pwd)
 		proc.Mask(pwd)
 		if err = proc.Run(); err != nil {
 			return err
@@ -1,10 +1,12 @@
 package project
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/argoproj/dev-tools/cmd/run/cluster"
@@ -35,7 +37,7 @@ func newCDLocalCommand() *cobra.Command {
 			if err := opts.checkPwd(); err != nil {
 				return err
 			}
-			return opts.local()
+			return opts.local(cmd.Context())
 		},
 	}
 
@@ -54,7 +56,7 @@ func newCDE2ECommand() *cobra.Command {
 			if err := opts.checkPwd(); err != nil {
 				return err
 			}
-			return opts.e2e()
+			return opts.e2e(cmd.Context())
 		},
 	}
 
@@ -63,24 +65,55 @@ func newCDE2ECommand() *cobra.Command {
 	return cmd
 }
 
+const (
+	apiServerHealthz = "http://localhost:8080/healthz"
+	// Generous, as the components are compiled before they start
+	apiServerStartTimeout = 15 * time.Minute
+	argoCdInitTimeout     = 10 * time.Minute
+	// The api-server is up already, so this only covers its initialization
+	argoCdLoginTimeout = 2 * time.Minute
+)
+
+// localArgoCdImage is what `make image` builds, with the IMAGE_NAMESPACE and IMAGE_TAG of buildLocalImage
+var localArgoCdImage = cluster.LocalImage{Name: "argo-dev-tools/argocd:local", Replaces: "quay.io/argoproj/argocd"}
+
 type cdOpts struct {
+	clusterOpts
 	progressiveSync bool
 	sourceHydrator  bool
+	nativeProcfile  bool
+	localImages     bool
 	applyResources  []string
 }
 
 func (opts *cdOpts) registerFlags(cmd *cobra.Command) {
+	opts.clusterOpts.registerFlags(cmd)
 	cmd.Flags().BoolVar(&opts.sourceHydrator, "source-hydrator", false, "Enable source hydrator")
 	cmd.Flags().BoolVar(&opts.progressiveSync, "progressive-sync", false, "Enable progressive sync")
+	cmd.Flags().BoolVar(&opts.nativeProcfile, "native-procfile", false, "Run Procfile components as individual processes instead of goreman (local only)")
+	cmd.Flags().BoolVar(&opts.localImages, "local-images", false, "Build the image of the project and run all the components in the cluster (local only)")
+	cmd.MarkFlagsMutuallyExclusive("local-images", "native-procfile")
+	// Configured through the environment of the local processes only
+	cmd.MarkFlagsMutuallyExclusive("local-images", "progressive-sync")
 	cmd.Flags().StringSliceVar(&opts.applyResources, "apply-resources", nil, "Specify resources to apply, namely AppProjects, Applications and AppSets")
 }
 
+// image replaces the released one in the deployed manifests, nil when running the released images.
+func (opts *cdOpts) image() *cluster.LocalImage {
+	if !opts.localImages {
+		return nil
+	}
+	image := localArgoCdImage
+	return &image
+}
+
 func (opts *cdOpts) checkPwd() error {
 	return run.CheckMarker("Makefile", regexp.MustCompile("^PACKAGE=github.com/argoproj/argo-cd/"))
 }
 
-func (opts *cdOpts) local() error {
-	cluster, err := startCluster("argocd")
+func (opts *cdOpts) local(ctx context.Context) error {
+	run.EnterPhase("cd-local", "cluster")
+	cluster, err := startCluster(ctx, opts.clusterOpts, "argocd")
 	if err != nil {
 		return err
 	}
@@ -94,15 +127,36 @@ func (opts *cdOpts) local() error {
 		manifestInstall = "manifests/install-with-hydrator.yaml"
 	}
 
-	if err := cluster.KubectlProc("create", "-f", manifestInstall).Run(); err != nil {
-		return fmt.Errorf("failed deploying argo-cd manifests from %q: %s", manifestInstall, err)
+	if opts.localImages {
+		run.EnterPhase("cd-local", "images")
+		if err := buildLocalImage(ctx, cluster); err != nil {
+			return err
+		}
+	}
+
+	run.EnterPhase("cd-local", "deploy")
+	if err := deployManifest(ctx, cluster, manifestInstall, opts.image()); err != nil {
+		return fmt.Errorf("failed deploying argo-cd manifests from %q: %w", manifestInstall, err)
 	}
 
-	if err := opts.doApplyResources(cluster); err != nil {
+	if err := opts.doApplyResources(ctx, cluster); err != nil {
 		return err
 	}
 
-	argoCdSecret := waitForArgoCdAdminSecret(cluster)
+	run.EnterPhase("cd-local", "initialize")
+	argoCdSecret, err := waitForArgoCdAdminSecret(ctx, cluster)
+	if err != nil {
+		return err
+	}
+	run.RegisterSecret(argoCdSecret)
+
+	if opts.localImages {
+		if err := run.CopyToClipboard(ctx, argoCdSecret); err != nil {
+			return err
+		}
+		run.EnterPhase("cd-local", "start")
+		return startInCluster(ctx, cluster)
+	}
 
 	phonyResources := []string{
 		"statefulset/argocd-application-controller",
@@ -116,16 +170,15 @@ func (opts *cdOpts) local() error {
 	if opts.sourceHydrator {
 		phonyResources = append(phonyResources, "deployment/argocd-commit-server")
 	}
-	if err := scaleToZero(cluster, phonyResources...); err != nil {
+	if err := scaleToZero(ctx, cluster, phonyResources...); err != nil {
 		return err
 	}
 
-	if err := run.CopyToClipboard(argoCdSecret); err != nil {
+	if err := run.CopyToClipboard(ctx, argoCdSecret); err != nil {
 		return err
 	}
 
-	go authenticateArgocdCli(argoCdSecret)
-
+	run.EnterPhase("cd-local", "start")
 	opArgs := []string{"make", "start-local"}
 	if opts.progressiveSync {
 		opArgs = append(opArgs, "ARGOCD_APPLICATIONSET_CONTROLLER_ENABLE_PROGRESSIVE_SYNCS=true")
@@ -133,13 +186,22 @@ func (opts *cdOpts) local() error {
 	if opts.sourceHydrator {
 		opArgs = append(opArgs, "ARGOCD_HYDRATOR_ENABLED=true")
 	}
-	mp := run.NewManagedProc(opArgs...)
-	mp.StdoutTransformer = outcolor.ColorizeGoreman
+	if opts.nativeProcfile {
+		return startLocalNative(ctx, cluster, argoCdSecret, opArgs)
+	}
+
+	mp := cluster.Proc(ctx, opArgs...)
+	mp.Stdout.Transform(outcolor.ColorizeGoreman)
+	// Recover from crashes of the local processes, the cluster is expensive to recreate
+	mp.Restart = run.RestartPolicy{Policy: run.RestartOnFailure, MaxRetries: 3}
+	mp.AddReadinessProbe(run.HTTPProbe(apiServerHealthz))
+	go loginWhenReady(ctx, mp, argoCdSecret)
 	return mp.Run()
 }
 
-func (opts *cdOpts) e2e() error {
-	cluster, err := startCluster("argocd")
+func (opts *cdOpts) e2e(ctx context.Context) error {
+	run.EnterPhase("cd-e2e", "cluster")
+	cluster, err := startCluster(ctx, opts.clusterOpts, "argocd")
 	if err != nil {
 		return err
 	}
@@ -148,20 +210,63 @@ func (opts *cdOpts) e2e() error {
 	}
 	defer cluster.Close()
 
-	go authenticateArgocdCli("password")
-
-	mp := run.NewManagedProc(
+	run.EnterPhase("cd-e2e", "start")
+	mp := cluster.Proc(
+		ctx,
 		"make", "start-e2e-local",
 		"ARGOCD_E2E_REPOSERVER_PORT=8088",
 		"COVERAGE_ENABLED=true",
 		"ARGOCD_FAKE_IN_CLUSTER=true",
 		"ARGOCD_E2E_K3S=true",
 	)
-	mp.StdoutTransformer = outcolor.ColorizeGoreman
+	mp.Stdout.Transform(outcolor.ColorizeGoreman)
+	mp.AddReadinessProbe(run.HTTPProbe(apiServerHealthz))
+	go loginWhenReady(ctx, mp, "password")
 	return mp.Run()
 }
 
-func (opts *cdOpts) doApplyResources(cluster *cluster.KubeCluster) error {
+// buildLocalImage builds the image of the project, and imports it into the cluster.
+func buildLocalImage(ctx context.Context, c *cluster.KubeCluster) error {
+	if err := run.NewManagedProc(ctx, "make", "image", "IMAGE_NAMESPACE=argo-dev-tools", "IMAGE_TAG=local").Run(); err != nil {
+		return fmt.Errorf("failed building image: %w", err)
+	}
+	return c.ImportImage(ctx, localArgoCdImage.Name)
+}
+
+// deployManifest creates the resources of the manifest, with the released image replaced by the local one, unless nil.
+func deployManifest(ctx context.Context, c *cluster.KubeCluster, manifest string, image *cluster.LocalImage) error {
+	deploy := []string{"create"}
+	if c.Reused {
+		// Cluster scoped resources, like CRDs, survive from the previous run
+		deploy = []string{"apply", "--server-side", "--force-conflicts"}
+	}
+	if image == nil {
+		return c.KubectlProc(ctx, append(deploy, "-f", manifest)...).Run()
+	}
+
+	content, err := os.ReadFile(manifest)
+	if err != nil {
+		return err
+	}
+	mp := c.KubectlProc(ctx, append(deploy, "-f", "-")...)
+	mp.Stdin(strings.NewReader(image.Rewrite(string(content))))
+	return mp.Run()
+}
+
+// startInCluster makes the api-server, running in the cluster, available on the same port as the local one, until interrupted.
+func startInCluster(ctx context.Context, c *cluster.KubeCluster) error {
+	if err := c.WaitForAllPodsRunning(ctx); err != nil {
+		return err
+	}
+
+	run.Out(os.Stderr, "Argo CD api-server available on https://localhost:8080")
+	mp := c.KubectlProc(ctx, "port-forward", "svc/argocd-server", "8080:443")
+	// Reconnect when the api-server pod is replaced
+	mp.Restart = run.RestartPolicy{Policy: run.RestartOnFailure}
+	return mp.Run()
+}
+
+func (opts *cdOpts) doApplyResources(ctx context.Context, cluster *cluster.KubeCluster) error {
 	for _, resource := range opts.applyResources {
 		fileInfo, err := os.Stat(resource)
 		if err != nil {
@@ -186,8 +291,8 @@ func (opts *cdOpts) doApplyResources(cluster *cluster.KubeCluster) error {
 		}
 
 		for _, file := range files {
-			if err := cluster.KubectlProc("create", "-f", file).Run(); err != nil {
-				return fmt.Errorf("failed deploying argo-cd manifests from %q: %s", file, err)
+			if err := cluster.KubectlProc(ctx, "create", "-f", file).Run(); err != nil {
+				return fmt.Errorf("failed deploying argo-cd manifests from %q: %w", file, err)
 			}
 		}
 	}
@@ -195,42 +300,52 @@ func (opts *cdOpts) doApplyResources(cluster *cluster.KubeCluster) error {
 	return nil
 }
 
-func waitForArgoCdAdminSecret(cluster *cluster.KubeCluster) string {
-	for {
-		secret, err := getInitialArgoCdAdminSecret(cluster)
-		if err == nil {
-			return secret
+func waitForArgoCdAdminSecret(ctx context.Context, c *cluster.KubeCluster) (string, error) {
+	var secret string
+	poller := run.Poller{Description: "Argo CD initialized", Timeout: argoCdInitTimeout, Interval: 5 * time.Second}
+	err := poller.Poll(ctx, func() (err error) {
+		secret, err = getInitialArgoCdAdminSecret(ctx, c)
+		if err != nil && !cluster.IsNotFound(err) {
+			return fmt.Errorf("failed reading admin secret: %w", err)
 		}
+		return err
+	})
+	return secret, err
+}
 
-		run.Out(os.Stderr, "Waiting for Argo CD initialized...")
-
-		if run.WasInterrupted() {
-			return ""
-		}
-		time.Sleep(5 * time.Second)
+// loginWhenReady authenticates ./dist/argocd once the api-server started by mp is up.
+func loginWhenReady(ctx context.Context, mp *run.ManagedProc, secret string) {
+	if err := mp.WaitReady(apiServerStartTimeout); err != nil {
+		run.Out(os.Stderr, "Not logging in ./dist/argocd: %s", err)
+		return
 	}
+	authenticateArgocdCli(ctx, secret)
 }
 
-func authenticateArgocdCli(secret string) {
-	for {
-		mp := run.NewManagedProc("./dist/argocd", "login", "--plaintext", "localhost:8080", "--username=admin", "--password="+secret)
-		if err := mp.Run(); err == nil {
-			break
-		}
-
-		run.Out(os.Stderr, "Waiting for ./dist/argocd login...")
-		time.Sleep(5 * time.Second)
+func authenticateArgocdCli(ctx context.Context, secret string) {
+	poller := run.Poller{Description: "./dist/argocd login", Timeout: argoCdLoginTimeout, Interval: 5 * time.Second}
+	err := poller.Poll(ctx, func() error {
+		return run.NewManagedProc(ctx, "./dist/argocd", "login", "--plaintext", "localhost:8080", "--username=admin", "--password="+secret).Run()
+	})
+	if err != nil {
+		run.Out(os.Stderr, "Not logging in ./dist/argocd: %s", err)
+		return
 	}
 
 	run.Out(os.Stderr, "./dist/argocd logged in!")
 }
 
-func getInitialArgoCdAdminSecret(c *cluster.KubeCluster) (string, error) {
+func getInitialArgoCdAdminSecret(ctx context.Context, c *cluster.KubeCluster) (string, error) {
 	proc := c.KubectlProc(
+		ctx,
 		"get", "secret", "argocd-initial-admin-secret",
 		"-o", "jsonpath={.data.password}",
 	)
 	stdoutBuffer := proc.CaptureStdout()
+	// Registered as a secret only once decoded, too late for masking
+	proc.Stdout.Sensitive()
+	// Missing secret is expected until Argo CD initializes, and reported by the caller
+	proc.Stderr.Silence()
 	if err := proc.Run(); err != nil {
 		return "", err
 	}
@@ -239,14 +354,15 @@ func getInitialArgoCdAdminSecret(c *cluster.KubeCluster) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	run.Emit(run.Event{Type: run.EventSecretObtained, Cluster: c.Name, Namespace: c.Namespace, Secret: "argocd-initial-admin-secret"})
 
 	return string(decoded), nil
 }
 
-func scaleToZero(c *cluster.KubeCluster, resources ...string) error {
+func scaleToZero(ctx context.Context, c *cluster.KubeCluster, resources ...string) error {
 	for _, resource := range resources {
-		if err := c.KubectlProc("scale", resource, "--replicas", "0").Run(); err != nil {
-			return fmt.Errorf("failed scaling down %s in the dummy Argo CD deployment: %v", resource, err)
+		if err := c.KubectlProc(ctx, "scale", resource, "--replicas", "0").Run(); err != nil {
+			return fmt.Errorf("failed scaling down %s in the dummy Argo CD deployment: %w", resource, err)
 		}
 	}
 	return nil
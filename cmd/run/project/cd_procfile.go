@@ -0,0 +1,163 @@
+package project
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/argoproj/dev-tools/cmd/run/cluster"
+	"github.com/argoproj/dev-tools/cmd/run/outcolor"
+	"github.com/argoproj/dev-tools/cmd/run/procfile"
+	"github.com/argoproj/dev-tools/cmd/run/run"
+)
+
+// goremanShim stands in for goreman on the PATH of `make start-local`, recording the environment and the arguments
+// the Makefile starts it with, instead of starting the Procfile.
+const goremanShim = `#!/bin/sh
+env > "$(dirname "$0")/env"
+printf '%s\n' "$@" > "$(dirname "$0")/args"
+`
+
+var envVarPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// startLocalNative does what `make start-local` does, running the Procfile entries as individual processes instead of goreman.
+// The Makefile still builds the binaries and prepares the environment, see makeStartLocal.
+func startLocalNative(ctx context.Context, c *cluster.KubeCluster, argoCdSecret string, makeArgs []string) error {
+	// Registered as a secret before the Makefile prints it
+	if err := registerRedisPassword(ctx, c); err != nil {
+		return err
+	}
+
+	procfilePath, names, makeEnv, err := makeStartLocal(ctx, c, makeArgs)
+	if err != nil {
+		return err
+	}
+
+	entries, err := procfile.Parse(procfilePath)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		entries = slices.DeleteFunc(entries, func(entry procfile.Entry) bool {
+			return !slices.Contains(names, entry.Name)
+		})
+	}
+	// As goreman, the environment of the Makefile takes precedence over .env
+	env, err := procfile.ParseEnv(".env")
+	if err != nil {
+		return err
+	}
+	env = append(env, makeEnv...)
+
+	runner := procfile.NewRunner(ctx, entries, env, outcolor.ColorizeGoreman)
+	for _, entry := range entries {
+		// Recover from crashes of individual components, without restarting the rest
+		runner.Proc(entry.Name).Restart = run.RestartPolicy{Policy: run.RestartOnFailure, MaxRetries: 3}
+	}
+	apiServer := runner.Proc("api-server")
+	if apiServer == nil {
+		return fmt.Errorf("no api-server entry in %s", procfilePath)
+	}
+	apiServer.AddReadinessProbe(run.HTTPProbe(apiServerHealthz))
+	go loginWhenReady(ctx, apiServer, argoCdSecret)
+
+	return runner.Run()
+}
+
+// makeStartLocal runs the make target starting goreman with goremanShim in its place, so the Makefile of the project
+// builds and prepares everything the Procfile needs. It returns the Procfile, the names of the entries to start,
+// empty for all, and the variables the Makefile sets for goreman.
+func makeStartLocal(ctx context.Context, c *cluster.KubeCluster, makeArgs []string) (string, []string, []string, error) {
+	if run.IsDryRun() {
+		// Nothing recorded by the described make, goreman defaults to the Procfile
+		return "Procfile", nil, nil, c.Proc(ctx, makeArgs...).Run()
+	}
+
+	shimDir, err := run.MkdirTemp("argo-dev-tools-goreman-*")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	// The recorded environment contains secrets
+	defer os.RemoveAll(shimDir)
+	if err := os.WriteFile(filepath.Join(shimDir, "goreman"), []byte(goremanShim), 0700); err != nil {
+		return "", nil, nil, err
+	}
+
+	mp := c.Proc(ctx, makeArgs...)
+	mp.AddEnv("PATH", shimDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if err := mp.Run(); err != nil {
+		return "", nil, nil, fmt.Errorf("failed preparing local processes: %w", err)
+	}
+
+	args, err := os.ReadFile(filepath.Join(shimDir, "args"))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("%s did not start goreman: %w", strings.Join(makeArgs, " "), err)
+	}
+	env, err := os.ReadFile(filepath.Join(shimDir, "env"))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("%s did not start goreman: %w", strings.Join(makeArgs, " "), err)
+	}
+
+	procfilePath, names := parseGoremanArgs(strings.Split(strings.TrimSuffix(string(args), "\n"), "\n"))
+	return procfilePath, names, changedEnv(string(env)), nil
+}
+
+// parseGoremanArgs extracts the Procfile and the names of the entries from `goreman [-f PROCFILE] start [NAME...]`.
+func parseGoremanArgs(args []string) (string, []string) {
+	procfilePath := "Procfile"
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-f" && i+1 < len(args):
+			procfilePath = args[i+1]
+			i++
+		case args[i] == "start":
+			return procfilePath, slices.DeleteFunc(args[i+1:], func(name string) bool { return name == "" })
+		}
+	}
+	return procfilePath, nil
+}
+
+// changedEnv picks the variables of the `env` output that differ from the environment of this process.
+// PATH is skipped, as it points to goremanShim.
+func changedEnv(out string) []string {
+	var vars []string
+	for line := range strings.Lines(out) {
+		line = strings.TrimSuffix(line, "\n")
+		if !envVarPattern.MatchString(line) && len(vars) > 0 {
+			// Continuation of a multi-line value
+			vars[len(vars)-1] += "\n" + line
+			continue
+		}
+		vars = append(vars, line)
+	}
+
+	return slices.DeleteFunc(vars, func(kv string) bool {
+		key, value, _ := strings.Cut(kv, "=")
+		current, found := os.LookupEnv(key)
+		return key == "PATH" || (found && current == value)
+	})
+}
+
+// registerRedisPassword masks the password the Makefile reads for the local processes.
+func registerRedisPassword(ctx context.Context, c *cluster.KubeCluster) error {
+	proc := c.KubectlProc(ctx, "get", "secret", "argocd-redis", "-o", "jsonpath={.data.auth}")
+	stdoutBuffer := proc.CaptureStdout()
+	// Registered as a secret only once decoded, too late for masking
+	proc.Stdout.Sensitive()
+	if err := proc.Run(); err != nil {
+		return fmt.Errorf("failed reading redis password: %w", err)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stdoutBuffer.String()))
+	if err != nil {
+		return fmt.Errorf("failed decoding redis password: %w", err)
+	}
+	run.RegisterSecret(string(decoded))
+	run.Emit(run.Event{Type: run.EventSecretObtained, Cluster: c.Name, Namespace: c.Namespace, Secret: "argocd-redis"})
+	return nil
+}
@@ -0,0 +1,47 @@
+package project
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/argoproj/dev-tools/cmd/run/cluster"
+)
+
+func TestMakeStartLocal(t *testing.T) {
+	t.Chdir(t.TempDir())
+	makefile := "start-local:\n" +
+		"\tmkdir -p local/gpg/keys\n" +
+		"\tARGOCD_GPG_ENABLED=$(GPG) BIN_MODE=true goreman -f Procfile.local start api-server $(ARGOCD_START)\n"
+	if err := os.WriteFile("Makefile", []byte(makefile), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &cluster.KubeCluster{Name: "test", Kubeconfig: "/tmp/kubeconfig-test"}
+	procfilePath, names, env, err := makeStartLocal(t.Context(), c, []string{"make", "start-local", "GPG=false", "ARGOCD_START=repo-server"})
+	if err != nil {
+		t.Fatalf("makeStartLocal() failed: %v", err)
+	}
+
+	if procfilePath != "Procfile.local" {
+		t.Errorf("unexpected Procfile %q", procfilePath)
+	}
+	if expected := []string{"api-server", "repo-server"}; !slices.Equal(names, expected) {
+		t.Errorf("expected entries %q, got %q", expected, names)
+	}
+	for _, expected := range []string{"ARGOCD_GPG_ENABLED=false", "BIN_MODE=true", "KUBECONFIG=/tmp/kubeconfig-test"} {
+		if !slices.Contains(env, expected) {
+			t.Errorf("expected %s in environment, got %q", expected, env)
+		}
+	}
+	if _, err := os.Stat("local/gpg/keys"); err != nil {
+		t.Errorf("expected Makefile to prepare the local dir: %v", err)
+	}
+}
+
+func TestParseGoremanArgs(t *testing.T) {
+	procfilePath, names := parseGoremanArgs([]string{"start", ""})
+	if procfilePath != "Procfile" || len(names) != 0 {
+		t.Errorf("expected all entries of the default Procfile, got %q %q", procfilePath, names)
+	}
+}
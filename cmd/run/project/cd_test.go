@@ -0,0 +1,332 @@
+package project
+
+import (
+	"encoding/base64"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/argoproj/dev-tools/cmd/run/cluster"
+	"github.com/argoproj/dev-tools/cmd/run/run"
+)
+
+var k3dOpts = clusterOpts{provider: "k3d"}
+
+// fakeCdLocal scripts the admin secret read from the cluster, and every other command succeeding silently.
+// Responses registered on fake beforehand take precedence.
+func fakeCdLocal(fake *run.FakeExecutor) *run.FakeExecutor {
+	fake.On(`^kubectl .* get secret argocd-initial-admin-secret `, run.FakeResponse{
+		Stdout: base64.StdEncoding.EncodeToString([]byte("s3cr3t")),
+	})
+	return fake.On(`.*`, run.FakeResponse{})
+}
+
+func TestCdLocal(t *testing.T) {
+	fake := fakeCdLocal(run.UseFakeExecutor(t))
+
+	var phases, secrets []string
+	unsubscribe := run.Subscribe(func(event run.Event) {
+		switch event.Type {
+		case run.EventWorkflowPhase:
+			phases = append(phases, event.Phase)
+		case run.EventSecretObtained:
+			secrets = append(secrets, event.Secret)
+		}
+	})
+	defer unsubscribe()
+
+	opts := cdOpts{clusterOpts: k3dOpts, sourceHydrator: true, progressiveSync: true}
+	if err := opts.local(t.Context()); err != nil {
+		t.Fatalf("local() failed: %v", err)
+	}
+
+	if expected := []string{"cluster", "deploy", "initialize", "start"}; !slices.Equal(phases, expected) {
+		t.Errorf("expected phases %q, got %q", expected, phases)
+	}
+	if expected := []string{"argocd-initial-admin-secret"}; !slices.Equal(secrets, expected) {
+		t.Errorf("expected secrets %q, got %q", expected, secrets)
+	}
+
+	expected := []string{
+		`^k3d cluster create --config \S+\.yaml argo-dev-tools$`,
+		`^k3d kubeconfig get argo-dev-tools$`,
+		`^kubectl --context k3d-argo-dev-tools create namespace argocd$`,
+		`^kubectl .* create -f manifests/install-with-hydrator.yaml$`,
+		`^kubectl .* scale deployment/argocd-commit-server --replicas 0$`,
+		`^xclip$`,
+		`^make start-local ARGOCD_APPLICATIONSET_CONTROLLER_ENABLE_PROGRESSIVE_SYNCS=true ARGOCD_HYDRATOR_ENABLED=true$`,
+	}
+	for _, pattern := range expected {
+		if fake.Count(pattern) != 1 {
+			t.Errorf("expected exactly one command matching %q, got: %q", pattern, fake.Invocations())
+		}
+	}
+	// Leftovers before creation, and the cluster itself after
+	if count := fake.Count(`^k3d cluster delete argo-dev-tools$`); count != 2 {
+		t.Errorf("expected cluster deleted twice, got %d", count)
+	}
+}
+
+func TestCdLocalFailedDeployment(t *testing.T) {
+	fake := run.UseFakeExecutor(t)
+	fake.On(`create -f manifests/install.yaml$`, run.FakeResponse{ExitCode: 1, Stderr: "boom\n"})
+	fake.On(`.*`, run.FakeResponse{})
+
+	opts := cdOpts{clusterOpts: k3dOpts}
+	if err := opts.local(t.Context()); err == nil {
+		t.Fatalf("local() expected to fail")
+	}
+
+	if fake.Count(`^make `) != 0 {
+		t.Errorf("make expected not to run after failed deployment: %q", fake.Invocations())
+	}
+	if fake.Count(`^k3d cluster delete argo-dev-tools$`) != 2 {
+		t.Errorf("cluster expected to be deleted after failure: %q", fake.Invocations())
+	}
+}
+
+func TestCdLocalKind(t *testing.T) {
+	fake := fakeCdLocal(run.UseFakeExecutor(t))
+
+	opts := cdOpts{clusterOpts: clusterOpts{provider: "kind"}}
+	if err := opts.local(t.Context()); err != nil {
+		t.Fatalf("local() failed: %v", err)
+	}
+
+	if fake.Count(`^kind create cluster --name argo-dev-tools `) != 1 {
+		t.Errorf("expected kind cluster created: %q", fake.Invocations())
+	}
+	if fake.Count(`^kubectl --context kind-argo-dev-tools create namespace argocd$`) != 1 {
+		t.Errorf("expected kind context used: %q", fake.Invocations())
+	}
+	if fake.Count(`^kind delete cluster --name argo-dev-tools$`) != 2 || fake.Count(`^k3d `) != 0 {
+		t.Errorf("expected only kind clusters deleted: %q", fake.Invocations())
+	}
+}
+
+func TestCdLocalExistingCluster(t *testing.T) {
+	fake := run.UseFakeExecutor(t)
+	fake.On(`^kubectl config current-context$`, run.FakeResponse{Stdout: "ci-cluster\n"})
+	fakeCdLocal(fake)
+
+	opts := cdOpts{clusterOpts: clusterOpts{provider: "existing"}}
+	if err := opts.local(t.Context()); err != nil {
+		t.Fatalf("local() failed: %v", err)
+	}
+
+	if fake.Count(`^kubectl --context ci-cluster create namespace argocd$`) != 1 {
+		t.Errorf("expected current context used: %q", fake.Invocations())
+	}
+	if fake.Count(` delete namespace `) != 0 {
+		t.Errorf("expected namespace of existing cluster not deleted: %q", fake.Invocations())
+	}
+	if fake.Count(`^kubectl config view --minify --flatten --context ci-cluster$`) != 1 {
+		t.Errorf("expected current context copied to own kubeconfig: %q", fake.Invocations())
+	}
+	if fake.Count(`^(k3d|kind|docker) `) != 0 {
+		t.Errorf("expected no cluster created nor deleted: %q", fake.Invocations())
+	}
+}
+
+func TestCdLocalReuseExistingCluster(t *testing.T) {
+	fake := fakeCdLocal(run.UseFakeExecutor(t))
+
+	opts := cdOpts{clusterOpts: clusterOpts{provider: "existing", context: "ci-cluster", reuse: true}}
+	if err := opts.local(t.Context()); err != nil {
+		t.Fatalf("local() failed: %v", err)
+	}
+
+	if fake.Count(`^kubectl --context ci-cluster delete namespace argocd --ignore-not-found --wait$`) != 1 {
+		t.Errorf("expected namespace wiped when asked for: %q", fake.Invocations())
+	}
+}
+
+func TestCdLocalReuseCluster(t *testing.T) {
+	digest, err := cluster.K3dProvider{}.SpecDigest("argo-dev-tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := run.UseFakeExecutor(t)
+	fake.On(` get configmap argo-dev-tools-cluster-spec `, run.FakeResponse{Stdout: digest})
+	fakeCdLocal(fake)
+
+	opts := cdOpts{clusterOpts: clusterOpts{provider: "k3d", reuse: true, keep: true}}
+	if err := opts.local(t.Context()); err != nil {
+		t.Fatalf("local() failed: %v", err)
+	}
+
+	if fake.Count(`^k3d cluster (create|delete) `) != 0 {
+		t.Errorf("expected cluster neither created nor deleted: %q", fake.Invocations())
+	}
+	expected := []string{
+		`^kubectl --context k3d-argo-dev-tools get --raw /readyz$`,
+		`^kubectl --context k3d-argo-dev-tools delete namespace argocd --ignore-not-found --wait$`,
+		`^kubectl --context k3d-argo-dev-tools create namespace argocd$`,
+		`^kubectl .* apply --server-side --force-conflicts -f manifests/install.yaml$`,
+	}
+	for _, pattern := range expected {
+		if fake.Count(pattern) != 1 {
+			t.Errorf("expected exactly one command matching %q, got: %q", pattern, fake.Invocations())
+		}
+	}
+}
+
+func TestCdLocalReuseChangedCluster(t *testing.T) {
+	digest, err := cluster.K3dProvider{}.SpecDigest("argo-dev-tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := run.UseFakeExecutor(t)
+	fake.On(` get configmap argo-dev-tools-cluster-spec `, run.FakeResponse{Stdout: digest})
+	fakeCdLocal(fake)
+
+	opts := cdOpts{clusterOpts: clusterOpts{provider: "k3d", reuse: true, spec: cluster.Spec{Agents: 2}}}
+	if err := opts.local(t.Context()); err != nil {
+		t.Fatalf("local() failed: %v", err)
+	}
+
+	if fake.Count(`^k3d cluster create `) != 1 {
+		t.Errorf("expected cluster recreated: %q", fake.Invocations())
+	}
+	if fake.Count(` delete namespace `) != 0 || fake.Count(` create -f manifests/install.yaml$`) != 1 {
+		t.Errorf("expected fresh cluster deployment: %q", fake.Invocations())
+	}
+	if fake.Count(`^kubectl --context k3d-argo-dev-tools -n kube-system create configmap argo-dev-tools-cluster-spec --from-literal=digest=[0-9a-f]{64}$`) != 1 {
+		t.Errorf("expected new spec recorded: %q", fake.Invocations())
+	}
+}
+
+func TestCdLocalReuseUnhealthyCluster(t *testing.T) {
+	fake := run.UseFakeExecutor(t)
+	fake.On(` get --raw /readyz$`, run.FakeResponse{ExitCode: 1, Stderr: "connection refused\n"})
+	fakeCdLocal(fake)
+
+	opts := cdOpts{clusterOpts: clusterOpts{provider: "k3d", reuse: true}}
+	if err := opts.local(t.Context()); err != nil {
+		t.Fatalf("local() failed: %v", err)
+	}
+
+	if fake.Count(`^k3d cluster create `) != 1 {
+		t.Errorf("expected cluster recreated: %q", fake.Invocations())
+	}
+	if fake.Count(` delete namespace `) != 0 || fake.Count(` create -f manifests/install.yaml$`) != 1 {
+		t.Errorf("expected fresh cluster deployment: %q", fake.Invocations())
+	}
+	if count := fake.Count(`^k3d cluster delete argo-dev-tools$`); count != 2 {
+		t.Errorf("expected cluster deleted twice, got %d", count)
+	}
+}
+
+func TestCdLocalKeepCluster(t *testing.T) {
+	fake := fakeCdLocal(run.UseFakeExecutor(t))
+
+	opts := cdOpts{clusterOpts: clusterOpts{provider: "k3d", keep: true}}
+	if err := opts.local(t.Context()); err != nil {
+		t.Fatalf("local() failed: %v", err)
+	}
+
+	if fake.Count(`^k3d cluster create `) != 1 {
+		t.Errorf("expected cluster created: %q", fake.Invocations())
+	}
+	// Only the leftovers before creation
+	if count := fake.Count(`^k3d cluster delete argo-dev-tools$`); count != 1 {
+		t.Errorf("expected cluster deleted once, got %d", count)
+	}
+}
+
+func TestCdLocalLocalImages(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll("manifests", 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("manifests/install.yaml", []byte("image: quay.io/argoproj/argocd:latest\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := run.UseFakeExecutor(t)
+	fake.On(`^docker image inspect `, run.FakeResponse{Stdout: "sha256:0123abcd\n"})
+	fakeCdLocal(fake)
+
+	opts := cdOpts{clusterOpts: k3dOpts, localImages: true}
+	if err := opts.local(t.Context()); err != nil {
+		t.Fatalf("local() failed: %v", err)
+	}
+
+	expected := []string{
+		`^make image IMAGE_NAMESPACE=argo-dev-tools IMAGE_TAG=local$`,
+		`^k3d image import argo-dev-tools/argocd:local --cluster argo-dev-tools$`,
+		`^kubectl .* create -f -$`,
+		`^kubectl .* port-forward svc/argocd-server 8080:443$`,
+	}
+	for _, pattern := range expected {
+		if fake.Count(pattern) != 1 {
+			t.Errorf("expected exactly one command matching %q, got: %q", pattern, fake.Invocations())
+		}
+	}
+	if fake.Count(` scale `) != 0 || fake.Count(`^make start-local`) != 0 {
+		t.Errorf("expected components not run locally: %q", fake.Invocations())
+	}
+}
+
+func TestCdLocalDryRun(t *testing.T) {
+	for name, opts := range map[string]cdOpts{
+		"default":         {clusterOpts: clusterOpts{provider: "k3d", spec: cluster.Spec{Agents: 1}}},
+		"local-images":    {clusterOpts: k3dOpts, localImages: true},
+		"native-procfile": {clusterOpts: k3dOpts, nativeProcfile: true},
+		"reuse-cluster":   {clusterOpts: clusterOpts{provider: "k3d", reuse: true, keep: true}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if err := os.MkdirAll("manifests", 0750); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile("manifests/install.yaml", []byte("image: quay.io/argoproj/argocd:latest\n"), 0640); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile("Procfile", []byte("api-server: dist/argocd-server\n"), 0640); err != nil {
+				t.Fatal(err)
+			}
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+			run.UseExecutor(t, run.DryRunExecutor{})
+
+			if err := opts.local(t.Context()); err != nil {
+				t.Fatalf("local() failed: %v", err)
+			}
+
+			if created, _ := os.ReadDir(tmp); len(created) != 0 {
+				t.Errorf("expected no temporary files under dry-run, got %v", created)
+			}
+		})
+	}
+}
+
+func TestCdLocalRecordReplay(t *testing.T) {
+	for _, provider := range []string{"k3d", "kind"} {
+		t.Run(provider, func(t *testing.T) {
+			cassette := t.TempDir() + "/cassette.jsonl"
+			fake := fakeCdLocal(run.NewFakeExecutor())
+			recorder, err := run.NewRecordingExecutor(t.Context(), fake, cassette)
+			if err != nil {
+				t.Fatal(err)
+			}
+			run.UseExecutor(t, recorder)
+
+			opts := cdOpts{clusterOpts: clusterOpts{provider: provider}}
+			if err := opts.local(t.Context()); err != nil {
+				t.Fatalf("recorded local() failed: %v", err)
+			}
+			// Closes the cassette
+			run.RunCleanups()
+
+			replayer, err := run.NewReplayExecutor(cassette)
+			if err != nil {
+				t.Fatal(err)
+			}
+			run.SetExecutor(replayer)
+			if err := opts.local(t.Context()); err != nil {
+				t.Fatalf("replayed local() failed: %v", err)
+			}
+		})
+	}
+}
@@ -1,28 +1,85 @@
 package project
 
 import (
+	"context"
+	"fmt"
 	"github.com/argoproj/dev-tools/cmd/run/cluster"
 	"github.com/argoproj/dev-tools/cmd/run/run"
+	"github.com/spf13/cobra"
+	"strings"
 )
 
-func startCluster(ns string) (*cluster.KubeCluster, error) {
-	err := run.CheckDocker()
+// clusterOpts selects the cluster the workflows run in.
+type clusterOpts struct {
+	provider string
+	context  string
+	reuse    bool
+	keep     bool
+	specFile string
+	spec     cluster.Spec
+}
+
+func (opts *clusterOpts) registerFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opts.provider, "cluster-provider", "k3d", "Provider of the cluster to run in, one of "+strings.Join(cluster.ProviderNames, ", "))
+	cmd.Flags().StringVar(&opts.context, "cluster-context", "", "Kubeconfig context of the existing cluster, current context when empty")
+	cmd.Flags().BoolVar(&opts.reuse, "reuse-cluster", false, "Reuse a healthy cluster of the same spec from the previous run, recreating only the workflow namespace, also of an existing cluster")
+	cmd.Flags().BoolVar(&opts.keep, "keep-cluster", false, "Keep the cluster running on exit, to be reused by the next run")
+
+	// k3d cluster spec, on top of the one from --cluster-spec
+	cmd.Flags().StringVar(&opts.specFile, "cluster-spec", "", "YAML file with the k3d cluster spec, overridden and extended by the other cluster flags")
+	cmd.Flags().StringVar(&opts.spec.Image, "k3s-image", "", "k3s image selecting the Kubernetes version, like rancher/k3s:v1.30.4-k3s1")
+	cmd.Flags().IntVar(&opts.spec.Servers, "cluster-servers", 0, "Number of k3d server nodes")
+	cmd.Flags().IntVar(&opts.spec.Agents, "cluster-agents", 0, "Number of k3d agent nodes")
+	cmd.Flags().StringVar(&opts.spec.APIPort, "cluster-api-port", "", "Expose the Kubernetes API on [HOST:]PORT")
+	cmd.Flags().StringArrayVar(&opts.spec.Ports, "cluster-port", nil, "Map HOST:CONTAINER[@NODEFILTER] port, on the load balancer by default")
+	cmd.Flags().StringArrayVar(&opts.spec.K3sArgs, "k3s-arg", nil, "Pass ARG[@NODEFILTER] to k3s")
+	cmd.Flags().StringArrayVar(&opts.spec.Volumes, "cluster-volume", nil, "Mount SOURCE:DEST[@NODEFILTER] volume")
+	cmd.Flags().StringVar(&opts.spec.RegistriesConfig, "registries-config", "", "registries.yaml of k3s, configuring mirrors and credentials")
+}
+
+func (opts *clusterOpts) newProvider() (cluster.Provider, error) {
+	spec := opts.spec
+	if opts.specFile != "" {
+		base, err := cluster.LoadSpec(opts.specFile)
+		if err != nil {
+			return nil, err
+		}
+		spec = base.Merge(opts.spec)
+	}
+	return cluster.NewProvider(opts.provider, opts.context, spec)
+}
+
+func startCluster(ctx context.Context, opts clusterOpts, ns string) (*cluster.KubeCluster, error) {
+	provider, err := opts.newProvider()
 	if err != nil {
 		return nil, err
 	}
-	if run.WasInterrupted() {
+	// Existing cluster does not need to run in docker
+	if _, existing := provider.(*cluster.ExistingProvider); !existing {
+		if err := run.CheckDocker(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if ctx.Err() != nil {
 		return nil, nil
 	}
 
-	cluster, err := cluster.NewK3dCluster("argo-dev-tools")
+	cluster, err := cluster.NewCluster(ctx, provider, "argo-dev-tools", cluster.Options{Reuse: opts.reuse, Keep: opts.keep})
 	if err != nil {
 		return nil, err
 	}
-	err = cluster.CreateNs(ns)
+	// The namespace of an existing cluster, not owned by the tool, is wiped only when asked for explicitly
+	if cluster.Reused && opts.reuse {
+		if err := cluster.DeleteNs(ctx, ns); err != nil {
+			cluster.Close()
+			return nil, fmt.Errorf("failed wiping namespace %s of the reused cluster: %w", ns, err)
+		}
+	}
+	err = cluster.CreateNs(ctx, ns)
 	if err != nil {
 		return nil, err
 	}
-	err = cluster.UseNs(ns)
+	err = cluster.UseNs(ctx, ns)
 	if err != nil {
 		return nil, err
 	}
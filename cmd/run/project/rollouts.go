@@ -1,6 +1,7 @@
 package project
 
 import (
+	"context"
 	"regexp"
 
 	"github.com/argoproj/dev-tools/cmd/run/outcolor"
@@ -18,22 +19,26 @@ func NewRolloutsCommand() *cobra.Command {
 }
 
 func newRolloutsE2ECommand() *cobra.Command {
-	return &cobra.Command{
+	opts := clusterOpts{}
+	cmd := &cobra.Command{
 		Use:   "e2e",
 		Short: "Run Argo Rollouts e2e workflow",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runRolloutsE2E()
+			return runRolloutsE2E(cmd.Context(), opts)
 		},
 	}
+	opts.registerFlags(cmd)
+	return cmd
 }
 
-func runRolloutsE2E() error {
+func runRolloutsE2E(ctx context.Context, opts clusterOpts) error {
 	err := run.CheckMarker("Makefile", regexp.MustCompile("^PACKAGE=github.com/argoproj/argo-rollouts$"))
 	if err != nil {
 		return err
 	}
 
-	cluster, err := startCluster("argo-rollouts")
+	run.EnterPhase("rollouts-e2e", "cluster")
+	cluster, err := startCluster(ctx, opts, "argo-rollouts")
 	if err != nil {
 		return err
 	}
@@ -42,15 +47,17 @@ func runRolloutsE2E() error {
 	}
 	defer cluster.Close()
 
-	if err = cluster.KubectlProc("apply", "-k", "manifests/crds").Run(); err != nil {
+	run.EnterPhase("rollouts-e2e", "deploy")
+	if err = cluster.KubectlProc(ctx, "apply", "-k", "manifests/crds").Run(); err != nil {
 		return err
 	}
-	if err = cluster.KubectlProc("apply", "-f", "test/e2e/crds").Run(); err != nil {
+	if err = cluster.KubectlProc(ctx, "apply", "-f", "test/e2e/crds").Run(); err != nil {
 		return err
 	}
 
-	mp := run.NewManagedProc("make", "start-e2e")
-	mp.StderrTransformer = outcolor.ColorizeGoLog
-	mp.StdoutTransformer = outcolor.ColorizeGoLog
+	run.EnterPhase("rollouts-e2e", "start")
+	mp := cluster.Proc(ctx, "make", "start-e2e")
+	mp.Stderr.Transform(outcolor.ColorizeGoLog)
+	mp.Stdout.Transform(outcolor.ColorizeGoLog)
 	return mp.Run()
 }
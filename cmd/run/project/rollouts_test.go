@@ -0,0 +1,65 @@
+package project
+
+import (
+	"os"
+	"testing"
+
+	"github.com/argoproj/dev-tools/cmd/run/run"
+)
+
+func chdirRolloutsProject(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(dir+"/Makefile", []byte("PACKAGE=github.com/argoproj/argo-rollouts\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+}
+
+func TestRolloutsE2E(t *testing.T) {
+	chdirRolloutsProject(t)
+	fake := run.UseFakeExecutor(t)
+	fake.On(`.*`, run.FakeResponse{})
+
+	if err := runRolloutsE2E(t.Context(), k3dOpts); err != nil {
+		t.Fatalf("runRolloutsE2E() failed: %v", err)
+	}
+
+	expected := []string{
+		`^kubectl --context k3d-argo-dev-tools create namespace argo-rollouts$`,
+		`^kubectl .* -n argo-rollouts apply -k manifests/crds$`,
+		`^kubectl .* -n argo-rollouts apply -f test/e2e/crds$`,
+		`^make start-e2e$`,
+	}
+	for _, pattern := range expected {
+		if fake.Count(pattern) != 1 {
+			t.Errorf("expected exactly one command matching %q, got: %q", pattern, fake.Invocations())
+		}
+	}
+}
+
+func TestRolloutsE2EWrongProject(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fake := run.UseFakeExecutor(t)
+
+	if err := runRolloutsE2E(t.Context(), k3dOpts); err == nil {
+		t.Fatalf("runRolloutsE2E() expected to fail outside of the project")
+	}
+	if invocations := fake.Invocations(); len(invocations) != 0 {
+		t.Errorf("no command expected to run, got: %q", invocations)
+	}
+}
+
+func TestRolloutsE2EFailedCrds(t *testing.T) {
+	chdirRolloutsProject(t)
+	fake := run.UseFakeExecutor(t)
+	fake.On(`apply -k manifests/crds$`, run.FakeResponse{ExitCode: 1})
+	fake.On(`.*`, run.FakeResponse{})
+
+	if err := runRolloutsE2E(t.Context(), k3dOpts); err == nil {
+		t.Fatalf("runRolloutsE2E() expected to fail")
+	}
+	if fake.Count(`^make `) != 0 {
+		t.Errorf("make expected not to run after failed CRD installation: %q", fake.Invocations())
+	}
+}
@@ -0,0 +1,210 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// replayedSecret stands in for the sensitive outputs on replay. It is the base64 of "replayed-secret", as the
+// workflows read secrets base64 encoded from kubectl, and need to decode them to keep going.
+const replayedSecret = "cmVwbGF5ZWQtc2VjcmV0"
+
+// CassetteEntry is a single recorded command execution, stored as one JSON line of a cassette file.
+// Temporary paths of WriteTemp and MkdirTemp are recorded by stable names, so they match across runs.
+// Registered secrets are redacted everywhere, so they are replayed masked. Sensitive outputs are not recorded at all,
+// see OutputStream.Sensitive, and replayed as replayedSecret.
+type CassetteEntry struct {
+	Args            []string `json:"args"`
+	Env             []string `json:"env,omitempty"`
+	Dir             string   `json:"dir,omitempty"`
+	Stdout          string   `json:"stdout"`
+	Stderr          string   `json:"stderr"`
+	SensitiveStdout bool     `json:"sensitiveStdout,omitempty"`
+	SensitiveStderr bool     `json:"sensitiveStderr,omitempty"`
+	ExitCode        int      `json:"exitCode"`
+}
+
+// redacted copies the entry with the secrets registered by now masked.
+func (e *CassetteEntry) redacted() *CassetteEntry {
+	redacted := *e
+	redacted.Args = nil
+	for _, arg := range e.Args {
+		redacted.Args = append(redacted.Args, Redact(arg))
+	}
+	redacted.Env = nil
+	for _, env := range e.Env {
+		redacted.Env = append(redacted.Env, Redact(env))
+	}
+	redacted.Stdout = Redact(e.Stdout)
+	redacted.Stderr = Redact(e.Stderr)
+	return &redacted
+}
+
+func newCassetteEntry(spec *ExecSpec) *CassetteEntry {
+	entry := &CassetteEntry{SensitiveStdout: spec.SensitiveStdout, SensitiveStderr: spec.SensitiveStderr}
+	for _, arg := range spec.Args {
+		entry.Args = append(entry.Args, Redact(stableTempPaths(arg)))
+	}
+	for _, env := range spec.Env {
+		entry.Env = append(entry.Env, Redact(stableTempPaths(env)))
+	}
+	entry.Dir = stableTempPaths(spec.Dir)
+	return entry
+}
+
+// RecordingExecutor writes every execution of its delegate to a cassette file.
+// Entries are appended as soon as the process exits, so the cassette survives an interrupted run.
+// Secrets are often registered only after they are read from an output, so the cassette is rewritten on cleanup,
+// with all the secrets known by then redacted.
+type RecordingExecutor struct {
+	delegate Executor
+	path     string
+
+	mu      sync.Mutex
+	file    *os.File
+	entries []*CassetteEntry
+}
+
+func NewRecordingExecutor(ctx context.Context, delegate Executor, path string) (*RecordingExecutor, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating cassette: %w", err)
+	}
+	r := &RecordingExecutor{delegate: delegate, path: path, file: file}
+	OnCleanup(ctx, "cassette "+path, 0, func(context.Context) error {
+		return r.close()
+	})
+	return r, nil
+}
+
+func (r *RecordingExecutor) Start(ctx context.Context, spec *ExecSpec) (Process, error) {
+	rp := &recordingProcess{recorder: r, entry: newCassetteEntry(spec)}
+
+	// Sensitive outputs are recorded empty
+	recordedSpec := *spec
+	if !spec.SensitiveStdout {
+		recordedSpec.Stdout = io.MultiWriter(spec.Stdout, &rp.stdout)
+	}
+	if !spec.SensitiveStderr {
+		recordedSpec.Stderr = io.MultiWriter(spec.Stderr, &rp.stderr)
+	}
+
+	proc, err := r.delegate.Start(ctx, &recordedSpec)
+	if err != nil {
+		return nil, err
+	}
+	rp.Process = proc
+	return rp, nil
+}
+
+func (r *RecordingExecutor) record(entry *CassetteEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return fmt.Errorf("cassette closed")
+	}
+	r.entries = append(r.entries, entry)
+	return json.NewEncoder(r.file).Encode(entry.redacted())
+}
+
+// close rewrites the cassette, redacting the secrets registered after the entries were appended.
+func (r *RecordingExecutor) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	for _, entry := range r.entries {
+		if err := encoder.Encode(entry.redacted()); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(r.path, content.Bytes(), 0640)
+}
+
+type recordingProcess struct {
+	Process
+	recorder *RecordingExecutor
+	entry    *CassetteEntry
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+}
+
+func (rp *recordingProcess) Wait() (ExitStatus, error) {
+	status, err := rp.Process.Wait()
+	if err != nil {
+		return status, err
+	}
+
+	rp.entry.Stdout = rp.stdout.String()
+	rp.entry.Stderr = rp.stderr.String()
+	rp.entry.ExitCode = status.Code
+	if err := rp.recorder.record(rp.entry); err != nil {
+		Out(os.Stderr, "Failed recording %q: %s", strings.Join(rp.entry.Args, " "), err)
+	}
+	return status, nil
+}
+
+// ReplayExecutor serves executions recorded in a cassette instead of running commands.
+// Every recorded entry is served once, to the first execution with the same arguments and working directory.
+type ReplayExecutor struct {
+	mu      sync.Mutex
+	entries []*CassetteEntry
+}
+
+func NewReplayExecutor(path string) (*ReplayExecutor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening cassette: %w", err)
+	}
+	defer file.Close()
+
+	re := &ReplayExecutor{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024) // Outputs of long-running processes can be large
+	for line := 1; scanner.Scan(); line++ {
+		entry := &CassetteEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, fmt.Errorf("malformed cassette %s:%d: %w", path, line, err)
+		}
+		re.entries = append(re.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading cassette %s: %w", path, err)
+	}
+	return re, nil
+}
+
+func (re *ReplayExecutor) Start(_ context.Context, spec *ExecSpec) (Process, error) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	wanted := newCassetteEntry(spec)
+	for i, entry := range re.entries {
+		if entry.Dir == wanted.Dir && slices.Equal(entry.Args, wanted.Args) {
+			re.entries = slices.Delete(re.entries, i, i+1)
+			response := FakeResponse{Stdout: entry.Stdout, Stderr: entry.Stderr, ExitCode: entry.ExitCode}
+			if entry.SensitiveStdout {
+				response.Stdout = replayedSecret
+			}
+			if entry.SensitiveStderr {
+				response.Stderr = replayedSecret
+			}
+			return &fakeProcess{spec, response}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no recorded execution left for %q", strings.Join(wanted.Args, " "))
+}
@@ -0,0 +1,111 @@
+package run
+
+import (
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCassetteRoundTrip(t *testing.T) {
+	cassette := t.TempDir() + "/cassette.jsonl"
+
+	fake := NewFakeExecutor()
+	fake.On(`^kubectl get secret`, FakeResponse{Stdout: "name: admin\npassword: cmVjb3JkZWQtczNjcjN0\n", Stderr: "warning\n"})
+	fake.On(`^make`, FakeResponse{ExitCode: 2})
+	recorder, err := NewRecordingExecutor(t.Context(), fake, cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseExecutor(t, recorder)
+	t.Cleanup(func() {
+		cleanups.hooks = nil
+	})
+
+	secret := NewManagedProc(t.Context(), "kubectl", "get", "secret")
+	secret.CaptureStdout()
+	if err := secret.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// Known only once read from the output, as base64 encoded
+	RegisterSecret("recorded-s3cr3t")
+	login := NewManagedProc(t.Context(), "make", "login", "PASSWORD=hunter2")
+	login.Mask("hunter2")
+	if err := login.Run(); err == nil {
+		t.Fatal("make expected to fail")
+	}
+
+	RunCleanups()
+	recorded, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(recorded), "hunter2") || strings.Contains(string(recorded), "cmVjb3JkZWQtczNjcjN0") {
+		t.Errorf("masked value recorded in cassette: %s", recorded)
+	}
+
+	replayer, err := NewReplayExecutor(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetExecutor(replayer)
+
+	secret = NewManagedProc(t.Context(), "kubectl", "get", "secret")
+	replayedOut := secret.CaptureStdout()
+	if err := secret.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "name: admin\npassword: *REDACTED*\n"; replayedOut.String() != expected {
+		t.Errorf("expected replayed stdout %q, got %q", expected, replayedOut)
+	}
+	login = NewManagedProc(t.Context(), "make", "login", "PASSWORD=hunter2")
+	login.Mask("hunter2")
+	if err := login.Run(); err == nil {
+		t.Error("replayed make expected to fail")
+	}
+
+	// Every entry is served once
+	if err := NewManagedProc(t.Context(), "kubectl", "get", "secret").Run(); err == nil {
+		t.Error("replaying exhausted entry expected to fail")
+	}
+}
+
+func TestCassetteSensitiveOutput(t *testing.T) {
+	cassette := t.TempDir() + "/cassette.jsonl"
+	fake := NewFakeExecutor()
+	fake.On(`^kubectl get secret`, FakeResponse{Stdout: "c2Vuc2l0aXZl"})
+	recorder, err := NewRecordingExecutor(t.Context(), fake, cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseExecutor(t, recorder)
+	t.Cleanup(func() {
+		cleanups.hooks = nil
+	})
+
+	secret := NewManagedProc(t.Context(), "kubectl", "get", "secret")
+	secret.CaptureStdout()
+	secret.Stdout.Sensitive()
+	if err := secret.Run(); err != nil {
+		t.Fatal(err)
+	}
+	RunCleanups()
+	if recorded, err := os.ReadFile(cassette); err != nil || strings.Contains(string(recorded), "c2Vuc2l0aXZl") {
+		t.Errorf("sensitive output recorded in cassette: %s %v", recorded, err)
+	}
+
+	replayer, err := NewReplayExecutor(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetExecutor(replayer)
+	secret = NewManagedProc(t.Context(), "kubectl", "get", "secret")
+	replayedOut := secret.CaptureStdout()
+	if err := secret.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// Decodes, as the real secret would
+	if decoded, err := base64.StdEncoding.DecodeString(replayedOut.String()); err != nil || string(decoded) != "replayed-secret" {
+		t.Errorf("expected replayed placeholder, got %q", replayedOut)
+	}
+}
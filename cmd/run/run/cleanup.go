@@ -2,53 +2,187 @@ package run
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
-var MainTt *taskTracker
+const (
+	// pendingTasksInterval is how often the tasks blocking the shutdown are reported
+	pendingTasksInterval = 3 * time.Second
+	// DefaultShutdownTimeout bounds waiting for the tasks after the first signal, before the exit is forced.
+	DefaultShutdownTimeout = 5 * time.Minute
+	// InterruptedExitCode is the exit code of a run shut down by a signal
+	InterruptedExitCode = 42
+	// ForcedExitCode is the exit code of a run shut down by a signal, without waiting for the tasks to complete
+	ForcedExitCode = 43
+)
+
+type taskTrackerKey struct{}
 
+// taskTracker keeps the tasks the shutdown waits for, within the context it is carried by.
 type taskTracker struct {
-	ctx    context.Context
-	cancel func()
+	cancel context.CancelFunc
 	count  *sync.WaitGroup
+
+	// mu guards the registry of active tasks, reported while shutting down
+	mu     sync.Mutex
+	tasks  map[int]*trackedTask
+	nextID int
+}
+
+// WithTaskTracker derives a context tracking the tasks, like ManagedProcs, started within it or its descendants.
+// Cancelling it interrupts the tasks, see HandleSignals.
+func WithTaskTracker(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	tt := &taskTracker{cancel: cancel, count: &sync.WaitGroup{}, tasks: map[int]*trackedTask{}}
+	return context.WithValue(ctx, taskTrackerKey{}, tt), cancel
+}
+
+// trackerFrom returns the tracker carried by ctx, or nil if there is none.
+func trackerFrom(ctx context.Context) *taskTracker {
+	tt, _ := ctx.Value(taskTrackerKey{}).(*taskTracker)
+	return tt
+}
+
+// useTask registers a named task the shutdown waits for until released, noop if ctx carries no tracker.
+func useTask(ctx context.Context, name string) func() {
+	tt := trackerFrom(ctx)
+	if tt == nil {
+		return func() {}
+	}
+	return tt.use(name)
+}
+
+// reportTask registers a named task, reported but not waited for by the shutdown, noop if ctx carries no tracker.
+func reportTask(ctx context.Context, name string) func() {
+	tt := trackerFrom(ctx)
+	if tt == nil {
+		return func() {}
+	}
+	return tt.register(name)
+}
+
+type trackedTask struct {
+	id      int
+	name    string
+	started time.Time
 }
 
-func (t *taskTracker) UseContext(name string) (context.Context, func()) {
+// use registers a named task, the shutdown waits for until released.
+func (t *taskTracker) use(name string) func() {
 	t.count.Add(1)
-	return t.ctx, func() {
+	unregister := t.register(name)
+	return func() {
+		unregister()
 		t.count.Done()
 	}
 }
 
-func WasInterrupted() bool {
-	select {
-	case <-MainTt.ctx.Done():
-		return true
-	default:
-		return false
+// register adds a named task to the registry, without the shutdown waiting for it.
+func (t *taskTracker) register(name string) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := t.nextID
+	t.nextID++
+	t.tasks[id] = &trackedTask{id, name, time.Now()}
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.tasks, id)
 	}
 }
 
-func init() {
-	ctx, cancel := context.WithCancel(context.Background())
-	MainTt = &taskTracker{ctx, cancel, &sync.WaitGroup{}}
+// pendingTasks describes the active tasks, the longest running first.
+func (t *taskTracker) pendingTasks() string {
+	t.mu.Lock()
+	tasks := make([]*trackedTask, 0, len(t.tasks))
+	for _, task := range t.tasks {
+		tasks = append(tasks, task)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].id < tasks[j].id
+	})
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Waiting for %d tasks to complete:", len(tasks)))
+	for _, task := range tasks {
+		// Names are captured at registration, possibly before all the secrets are known
+		sb.WriteString(fmt.Sprintf("\n  %s (running for %s)", Redact(task.name), time.Since(task.started).Round(time.Second)))
+	}
+	return sb.String()
+}
+
+// HandleSignals makes SIGINT and SIGTERM cancel ctx, created by WithTaskTracker, so the workflow winds down.
+// The caller owns the shutdown: it waits for the tasks, see WaitForTasks, cleans up the resources and exits with
+// InterruptedExitCode. Another signal, or the shutdownTimeout elapsing, forces the exit sooner, with ForcedExitCode.
+// 0 shutdownTimeout waits indefinitely.
+func HandleSignals(ctx context.Context, shutdownTimeout time.Duration) {
+	tt := trackerFrom(ctx)
+	if tt == nil {
+		panic("context carries no task tracker")
+	}
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
-	go onSignal(signals)
+	go tt.onSignal(signals, shutdownTimeout)
+}
+
+// WaitForTasks blocks until the tasks of the tracker carried by ctx complete, noop if ctx carries no tracker.
+func WaitForTasks(ctx context.Context) {
+	if tt := trackerFrom(ctx); tt != nil {
+		tt.count.Wait()
+	}
 }
 
-func onSignal(signals chan os.Signal) {
+// onSignal cancels the tasks and reports them until they complete, then keeps forcing the exit on another signal
+// or the deadline, as long as the caller is shutting down.
+func (t *taskTracker) onSignal(signals chan os.Signal, shutdownTimeout time.Duration) {
 	sig := <-signals
-	Out(os.Stderr, "Caught signal %v", sig)
+	Out(os.Stderr, "Caught signal %v, interrupt again to force exit", sig)
+
+	t.cancel()
+	completed := make(chan struct{})
+	go func() {
+		t.count.Wait()
+		close(completed)
+	}()
+
+	var deadline <-chan time.Time
+	if shutdownTimeout > 0 {
+		deadline = time.After(shutdownTimeout)
+	}
+	ticker := time.NewTicker(pendingTasksInterval)
+	defer ticker.Stop()
+	Out(os.Stderr, "%s", t.pendingTasks())
+	for {
+		select {
+		case <-completed:
+			Out(os.Stderr, "All tasks completed")
+			// Never ready again, the caller exits meanwhile
+			completed = nil
+			ticker.Stop()
+		case <-ticker.C:
+			Out(os.Stderr, "%s", t.pendingTasks())
+		case sig := <-signals:
+			forceExit(fmt.Sprintf("Caught signal %v again", sig))
+		case <-deadline:
+			forceExit(fmt.Sprintf("Shutdown did not complete in %s", shutdownTimeout))
+		}
+	}
+}
 
-	MainTt.cancel()
-	Out(os.Stderr, "Waiting for tasks to complete")
-	MainTt.count.Wait()
-	Out(os.Stderr, "All tasks completed")
+// forceExit terminates the program without waiting for the tasks, after a time-bounded cleanup of the resources.
+func forceExit(reason string) {
+	Out(os.Stderr, "%s, forcing exit after cleanup of at most %s", reason, forceCleanupTimeout)
+	runCleanupsWithin(forceCleanupTimeout)
 
-	os.Exit(42)
+	ReportResources()
+	os.Exit(ForcedExitCode)
 }
@@ -0,0 +1,143 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// DefaultCleanupTimeout bounds a cleanup hook registered with no timeout.
+	DefaultCleanupTimeout = 2 * time.Minute
+	// forceCleanupTimeout bounds all the cleanups when the shutdown is forced.
+	forceCleanupTimeout = 30 * time.Second
+)
+
+var cleanups = &cleanupRegistry{}
+
+type cleanupRegistry struct {
+	mu    sync.Mutex
+	hooks []*CleanupHook
+}
+
+// CleanupHook tears down a resource, like a cluster or a temp dir, the program is responsible for removing.
+type CleanupHook struct {
+	ctx         context.Context
+	description string
+	timeout     time.Duration
+	teardown    func(ctx context.Context) error
+
+	once sync.Once
+	err  error
+}
+
+// OnCleanup registers the teardown to run on exit, be it a normal return, an error or a signal.
+// Hooks run in reverse order of registration, unless they are Run explicitly before.
+// The teardown gets a context with the timeout and the values of ctx, that is not cancelled with ctx.
+func OnCleanup(ctx context.Context, description string, timeout time.Duration, teardown func(ctx context.Context) error) *CleanupHook {
+	if timeout <= 0 {
+		timeout = DefaultCleanupTimeout
+	}
+	hook := &CleanupHook{ctx: context.WithoutCancel(ctx), description: description, timeout: timeout, teardown: teardown}
+
+	cleanups.mu.Lock()
+	defer cleanups.mu.Unlock()
+	cleanups.hooks = append(cleanups.hooks, hook)
+	return hook
+}
+
+// Run tears the resource down now, unless it has been already. Calling it repeatedly returns the original result.
+// Failed hooks are reported, and kept registered so they are listed as left behind.
+func (h *CleanupHook) Run() error {
+	h.once.Do(func() {
+		h.err = h.run()
+		if h.err != nil {
+			Out(os.Stderr, color.RedString("Failed cleaning up %s: %s", h.description, h.err))
+			return
+		}
+		cleanups.remove(h)
+	})
+	return h.err
+}
+
+func (h *CleanupHook) run() error {
+	ctx, cancel := context.WithTimeout(h.ctx, h.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.teardown(ctx)
+	}()
+	// Do not rely on the teardown to respect the context
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", h.timeout)
+	}
+}
+
+func (r *cleanupRegistry) remove(hook *CleanupHook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = slices.DeleteFunc(r.hooks, func(h *CleanupHook) bool {
+		return h == hook
+	})
+}
+
+func (r *cleanupRegistry) all() []*CleanupHook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.hooks)
+}
+
+// RunCleanups runs all the registered hooks, the most recently registered first, and reports what was left behind.
+func RunCleanups() {
+	hooks := cleanups.all()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		_ = hooks[i].Run()
+	}
+
+	if leftovers := cleanups.leftovers(); leftovers != "" {
+		Out(os.Stderr, color.YellowString(leftovers))
+	}
+}
+
+// runCleanupsWithin gives up on the cleanups after the timeout, reporting what was left behind.
+func runCleanupsWithin(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		RunCleanups()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		Out(os.Stderr, "Cleanup not completed in %s, giving up", timeout)
+		if leftovers := cleanups.leftovers(); leftovers != "" {
+			Out(os.Stderr, color.YellowString(leftovers))
+		}
+	}
+}
+
+// leftovers describes the resources not cleaned up, or empty string if there are none.
+func (r *cleanupRegistry) leftovers() string {
+	hooks := r.all()
+	if len(hooks) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Resources possibly left behind, clean them up manually:")
+	for _, hook := range hooks {
+		sb.WriteString("\n  " + hook.description)
+	}
+	return sb.String()
+}
@@ -0,0 +1,85 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCleanups(t *testing.T) {
+	t.Cleanup(func() {
+		cleanups.hooks = nil
+	})
+
+	var order []string
+	hook := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+	OnCleanup(t.Context(), "first", 0, hook("first"))
+	closed := OnCleanup(t.Context(), "closed", 0, hook("closed"))
+	OnCleanup(t.Context(), "failing", 0, func(context.Context) error {
+		return errors.New("boom")
+	})
+	OnCleanup(t.Context(), "hanging", 10*time.Millisecond, func(ctx context.Context) error {
+		time.Sleep(time.Minute)
+		return nil
+	})
+	OnCleanup(t.Context(), "last", 0, hook("last"))
+
+	if err := closed.Run(); err != nil {
+		t.Fatal(err)
+	}
+	RunCleanups()
+	// Repeated runs are noop
+	_ = closed.Run()
+	RunCleanups()
+
+	if expected := []string{"closed", "last", "first"}; !slices.Equal(order, expected) {
+		t.Errorf("expected hooks run in order %q, got %q", expected, order)
+	}
+	leftovers := cleanups.leftovers()
+	if !strings.Contains(leftovers, "failing") || !strings.Contains(leftovers, "hanging") {
+		t.Errorf("expected failed and timed out hooks reported, got %q", leftovers)
+	}
+	if strings.Contains(leftovers, "first") || strings.Contains(leftovers, "last") {
+		t.Errorf("expected completed hooks not reported, got %q", leftovers)
+	}
+}
+
+func TestRunCleanupsWithin(t *testing.T) {
+	t.Cleanup(func() {
+		cleanups.hooks = nil
+	})
+
+	cleaned := make(chan struct{})
+	OnCleanup(t.Context(), "hanging", 0, func(ctx context.Context) error {
+		time.Sleep(time.Minute)
+		return nil
+	})
+	OnCleanup(t.Context(), "cleanable", 0, func(context.Context) error {
+		close(cleaned)
+		return nil
+	})
+
+	started := time.Now()
+	runCleanupsWithin(50 * time.Millisecond)
+
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("expected forced cleanup to give up, took %s", elapsed)
+	}
+	select {
+	case <-cleaned:
+	default:
+		t.Errorf("expected hook cleaned up before the hanging one")
+	}
+	leftovers := cleanups.leftovers()
+	if !strings.Contains(leftovers, "hanging") || strings.Contains(leftovers, "cleanable") {
+		t.Errorf("expected only unfinished hooks reported, got %q", leftovers)
+	}
+}
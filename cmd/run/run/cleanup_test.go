@@ -0,0 +1,42 @@
+package run
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPendingTasks(t *testing.T) {
+	ctx, cancel := WithTaskTracker(t.Context())
+	defer cancel()
+	tt := trackerFrom(ctx)
+
+	RegisterSecret("t4sk-s3cret")
+	releaseFirst := useTask(ctx, "first t4sk-s3cret")
+	releaseSecond := reportTask(context.WithoutCancel(ctx), "second")
+
+	pending := tt.pendingTasks()
+	if !strings.HasPrefix(pending, "Waiting for 2 tasks") || !strings.Contains(pending, "first *REDACTED* (running for") {
+		t.Errorf("unexpected pending tasks: %q", pending)
+	}
+	if strings.Index(pending, "first") > strings.Index(pending, "second") {
+		t.Errorf("expected the longest running task first: %q", pending)
+	}
+
+	releaseFirst()
+	releaseSecond()
+	tt.count.Wait()
+	if pending := tt.pendingTasks(); pending != "Waiting for 0 tasks to complete:" {
+		t.Errorf("expected no pending tasks, got %q", pending)
+	}
+}
+
+func TestNoTaskTracker(t *testing.T) {
+	if trackerFrom(t.Context()) != nil {
+		t.Fatal("expected no tracker")
+	}
+	// Processes work with no tracker, as in library use
+	if err := NewManagedProc(t.Context(), "true").Run(); err != nil {
+		t.Fatal(err)
+	}
+}
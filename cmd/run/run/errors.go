@@ -0,0 +1,99 @@
+package run
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const stderrTailLines = 20
+
+// ProcessError reports a ManagedProc that did not complete successfully.
+type ProcessError struct {
+	// Args of the command, with secrets redacted.
+	Args []string
+	// ExitCode is -1 when the process did not exit on its own.
+	ExitCode int
+	Signal   syscall.Signal
+	Duration time.Duration
+	// StderrTail holds the last lines the process printed to stderr, with secrets redacted.
+	StderrTail []string
+	Err        error
+}
+
+// Error includes the last stderr line, usually the reason, so it reaches the user also when stderr is silenced.
+func (e *ProcessError) Error() string {
+	for i := len(e.StderrTail) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(e.StderrTail[i]); line != "" {
+			return fmt.Sprintf("failed: %s: %s", e.Err, line)
+		}
+	}
+	return fmt.Sprintf("failed: %s", e.Err)
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// StderrContains checks if any of the last stderr lines contains the string.
+func (e *ProcessError) StderrContains(s string) bool {
+	for _, line := range e.StderrTail {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Report describes the failure in detail, for the user to act on.
+func (e *ProcessError) Report() string {
+	var sb strings.Builder
+	sb.WriteString("Command failed: $ " + strings.Join(e.Args, " ") + "\n")
+	if e.Signal != 0 {
+		sb.WriteString("  signal:    " + e.Signal.String() + "\n")
+	} else if e.ExitCode >= 0 {
+		sb.WriteString(fmt.Sprintf("  exit code: %d\n", e.ExitCode))
+	}
+	sb.WriteString(fmt.Sprintf("  duration:  %s\n", e.Duration.Truncate(time.Millisecond)))
+	sb.WriteString(fmt.Sprintf("  error:     %s\n", e.Err))
+	if len(e.StderrTail) > 0 {
+		sb.WriteString(fmt.Sprintf("  stderr (last %d lines):\n", len(e.StderrTail)))
+		for _, line := range e.StderrTail {
+			sb.WriteString("    " + line + "\n")
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// lineTail keeps the last lines written to a stream.
+type lineTail struct {
+	mu    sync.Mutex
+	size  int
+	lines []string
+}
+
+func newLineTail(size int) *lineTail {
+	return &lineTail{size: size}
+}
+
+func (t *lineTail) add(line string) {
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.lines) == t.size {
+		t.lines = t.lines[1:]
+	}
+	t.lines = append(t.lines, line)
+}
+
+func (t *lineTail) get() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.lines...)
+}
@@ -0,0 +1,53 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProcessError(t *testing.T) {
+	RegisterSecret("pa55word")
+	mp := NewManagedProc(t.Context(), "sh", "-c", "echo first >&2; echo 'bad pa55word' >&2; exit 7", "pa55word")
+
+	err := fmt.Errorf("wrapped: %w", mp.Run())
+	var procErr *ProcessError
+	if !errors.As(err, &procErr) {
+		t.Fatalf("expected ProcessError, got %T: %v", err, err)
+	}
+
+	if procErr.ExitCode != 7 || procErr.Signal != 0 {
+		t.Errorf("unexpected exit: %d %v", procErr.ExitCode, procErr.Signal)
+	}
+	if expected := []string{"first", "bad *REDACTED*"}; !slices.Equal(procErr.StderrTail, expected) {
+		t.Errorf("expected stderr tail %q, got %q", expected, procErr.StderrTail)
+	}
+	if !procErr.StderrContains("bad") || procErr.StderrContains("pa55word") {
+		t.Errorf("unexpected stderr tail: %q", procErr.StderrTail)
+	}
+	if expected := "wrapped: failed: exit status 7: bad *REDACTED*"; err.Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, err)
+	}
+	if strings.Contains(procErr.Report(), "pa55word") {
+		t.Errorf("secret leaked into report: %s", procErr.Report())
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("expected original *exec.ExitError wrapped, got %v", procErr.Err)
+	}
+}
+
+func TestProcessErrorNotStarted(t *testing.T) {
+	err := NewManagedProc(t.Context(), "/nonexistent/command").Run()
+	var procErr *ProcessError
+	if !errors.As(err, &procErr) {
+		t.Fatalf("expected ProcessError, got %T: %v", err, err)
+	}
+	if procErr.ExitCode != -1 {
+		t.Errorf("expected no exit code, got %d", procErr.ExitCode)
+	}
+}
@@ -0,0 +1,125 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventProcessStarted   EventType = "process.started"
+	EventProcessReady     EventType = "process.ready"
+	EventProcessExited    EventType = "process.exited"
+	EventClusterCreated   EventType = "cluster.created"
+	EventClusterDeleted   EventType = "cluster.deleted"
+	EventNamespaceCreated EventType = "namespace.created"
+	EventSecretObtained   EventType = "secret.obtained"
+	EventWorkflowPhase    EventType = "workflow.phase"
+)
+
+// Event describes a step in the lifecycle of a workflow, for machine consumption.
+// Only the fields relevant for the Type are set, and secrets are redacted in all of them.
+type Event struct {
+	Time time.Time `json:"time"`
+	Type EventType `json:"type"`
+
+	// Process events
+	Command  string        `json:"command,omitempty"`
+	Label    string        `json:"label,omitempty"`
+	Pid      int           `json:"pid,omitempty"`
+	Attempt  int           `json:"attempt,omitempty"`
+	ExitCode *int          `json:"exitCode,omitempty"`
+	Signal   string        `json:"signal,omitempty"`
+	Duration time.Duration `json:"durationNs,omitempty"`
+	Error    string        `json:"error,omitempty"`
+
+	// Cluster and namespace events
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+
+	// Secret events name the secret, never its value
+	Secret string `json:"secret,omitempty"`
+
+	// Workflow events
+	Workflow string `json:"workflow,omitempty"`
+	Phase    string `json:"phase,omitempty"`
+}
+
+var events = &eventBus{}
+
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers []*subscriber
+}
+
+type subscriber struct {
+	handler func(Event)
+}
+
+// Subscribe registers the handler to be called with every event emitted, until unsubscribed.
+// Handlers are called synchronously by the emitting goroutine, so they need to be safe for concurrent use.
+// They can emit events and unsubscribe themselves.
+func Subscribe(handler func(Event)) (unsubscribe func()) {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	sub := &subscriber{handler}
+	events.subscribers = append(events.subscribers, sub)
+	return func() {
+		events.mu.Lock()
+		defer events.mu.Unlock()
+		events.subscribers = slices.DeleteFunc(events.subscribers, func(s *subscriber) bool {
+			return s == sub
+		})
+	}
+}
+
+// Emit delivers the event to all subscribers, filling in the time if not set.
+func Emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Command = Redact(event.Command)
+	event.Error = Redact(event.Error)
+
+	// Handlers are called unlocked, so they can use the bus themselves
+	events.mu.Lock()
+	subscribers := slices.Clone(events.subscribers)
+	events.mu.Unlock()
+	for _, sub := range subscribers {
+		sub.handler(event)
+	}
+}
+
+// EnterPhase reports the workflow entered its next phase.
+func EnterPhase(workflow string, phase string) {
+	Emit(Event{Type: EventWorkflowPhase, Workflow: workflow, Phase: phase})
+}
+
+// SetEventsFile writes all the events emitted from now on to the path, as JSON lines.
+func SetEventsFile(ctx context.Context, path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return fmt.Errorf("failed opening events file: %w", err)
+	}
+
+	encoder := json.NewEncoder(file)
+	var mu sync.Mutex
+	unsubscribe := Subscribe(func(event Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		// Events are best-effort, failing to write them should not take the workflow down
+		_ = encoder.Encode(event)
+	})
+	// Registered early, so it runs last and records the events of the other cleanups
+	OnCleanup(ctx, "events file "+path, 0, func(context.Context) error {
+		unsubscribe()
+		return file.Close()
+	})
+	return nil
+}
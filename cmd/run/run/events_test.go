@@ -0,0 +1,101 @@
+package run
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProcessEvents(t *testing.T) {
+	var received []Event
+	unsubscribe := Subscribe(func(event Event) {
+		received = append(received, event)
+	})
+	defer unsubscribe()
+
+	RegisterSecret("ev3nt-s3cret")
+	mp := NewManagedProc(t.Context(), "sh", "-c", "exit 3", "ev3nt-s3cret")
+	mp.Label("failing")
+	_ = mp.Run()
+
+	if len(received) != 2 || received[0].Type != EventProcessStarted || received[1].Type != EventProcessExited {
+		t.Fatalf("expected started and exited events, got %+v", received)
+	}
+	started, exited := received[0], received[1]
+	if started.Pid == 0 || started.Attempt != 1 || started.Label != "failing" {
+		t.Errorf("unexpected started event: %+v", started)
+	}
+	if strings.Contains(started.Command, "ev3nt-s3cret") {
+		t.Errorf("secret leaked into event: %q", started.Command)
+	}
+	if exited.ExitCode == nil || *exited.ExitCode != 3 || exited.Error == "" {
+		t.Errorf("unexpected exited event: %+v", exited)
+	}
+}
+
+func TestEventsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	if err := SetEventsFile(t.Context(), path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		events.subscribers = nil
+		cleanups.hooks = nil
+	}()
+
+	EnterPhase("test", "first")
+	Emit(Event{Type: EventClusterCreated, Cluster: "c"})
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var types []EventType
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event line %q: %v", scanner.Text(), err)
+		}
+		if event.Time.IsZero() {
+			t.Errorf("event time not set: %q", scanner.Text())
+		}
+		types = append(types, event.Type)
+	}
+	if len(types) != 2 || types[0] != EventWorkflowPhase || types[1] != EventClusterCreated {
+		t.Errorf("unexpected events written: %v", types)
+	}
+}
+
+func TestReentrantHandlers(t *testing.T) {
+	defer func() {
+		events.subscribers = nil
+	}()
+
+	var received []EventType
+	var unsubscribe func()
+	unsubscribe = Subscribe(func(event Event) {
+		received = append(received, event.Type)
+		if event.Type == EventClusterCreated {
+			unsubscribe()
+			Emit(Event{Type: EventNamespaceCreated})
+		}
+	})
+	Subscribe(func(event Event) {
+		received = append(received, "second:"+event.Type)
+	})
+
+	Emit(Event{Type: EventClusterCreated})
+	Emit(Event{Type: EventClusterDeleted})
+
+	expected := []EventType{EventClusterCreated, "second:" + EventNamespaceCreated, "second:" + EventClusterCreated, "second:" + EventClusterDeleted}
+	if !slices.Equal(received, expected) {
+		t.Errorf("expected events %q, got %q", expected, received)
+	}
+}
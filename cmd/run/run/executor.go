@@ -0,0 +1,316 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// ExecSpec describes a single execution of a command.
+type ExecSpec struct {
+	Args []string
+	Dir  string
+	// Env lists KEY=VALUE pairs added on top of the environment of this process.
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// GracePeriod is the time to wait for the process to terminate after cancellation before it is killed.
+	// DefaultGracePeriod is used when zero.
+	GracePeriod time.Duration
+
+	// SensitiveStdout and SensitiveStderr outputs must not be persisted, see OutputStream.Sensitive.
+	SensitiveStdout bool
+	SensitiveStderr bool
+}
+
+// DefaultGracePeriod is the GracePeriod of processes that do not configure their own.
+var DefaultGracePeriod = 10 * time.Second
+
+// Executor starts processes on behalf of ManagedProc.
+type Executor interface {
+	Start(ctx context.Context, spec *ExecSpec) (Process, error)
+}
+
+// Process is a command started by an Executor.
+type Process interface {
+	Pid() int
+	// Signal delivers the signal to the process and all its descendants.
+	Signal(sig syscall.Signal) error
+	// Wait blocks until the process exits and all its output is written to the spec writers.
+	// The error is reserved for failures to wait, unsuccessful exits are reported by ExitStatus.
+	Wait() (ExitStatus, error)
+}
+
+// ExitStatus describes how a Process terminated.
+type ExitStatus struct {
+	// Code is the exit code, -1 when the process was terminated by a signal.
+	Code   int
+	Signal syscall.Signal
+	// Orphans lists PIDs of descendants still running after the process exited.
+	Orphans []int
+	// Err is the executor specific error describing an unsuccessful exit, if any.
+	Err error
+
+	UserTime   time.Duration
+	SystemTime time.Duration
+	// MaxRSS is the peak resident set size in bytes, 0 if unknown.
+	MaxRSS int64
+}
+
+func (es ExitStatus) Success() bool {
+	return es.Code == 0
+}
+
+// String mimics the format of exec.ExitError.
+func (es ExitStatus) String() string {
+	if es.Code == -1 && es.Signal != 0 {
+		return "signal: " + es.Signal.String()
+	}
+	return fmt.Sprintf("exit status %d", es.Code)
+}
+
+var executor Executor = OsExecutor{}
+
+// SetExecutor replaces the Executor used by all ManagedProcs started from now on.
+func SetExecutor(e Executor) {
+	executor = e
+}
+
+// OsExecutor runs commands as child processes of this process.
+type OsExecutor struct{}
+
+func (OsExecutor) Start(ctx context.Context, spec *ExecSpec) (Process, error) {
+	cmd := exec.CommandContext(ctx, spec.Args[0], spec.Args[1:]...)
+	cmd.Dir = spec.Dir
+	if spec.Env != nil {
+		cmd.Env = append(os.Environ(), spec.Env...)
+	}
+	cmd.Stdin = spec.Stdin
+	cmd.Stdout = spec.Stdout
+	cmd.Stderr = spec.Stderr
+
+	// Start all children processes in one process group to deliver signals to all of them in one go.
+	// This speeds up termination of goreman significantly.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	grace := spec.GracePeriod
+	if grace <= 0 {
+		grace = DefaultGracePeriod
+	}
+	proc := &osProcess{cmd: cmd}
+
+	// Replace default handler from exec.CommandContext, use SIGTERM over SIGKILL.
+	// Kill the whole process group if it does not terminate within the grace period.
+	cmd.Cancel = func() error {
+		proc.killAfter(grace)
+		return proc.Signal(syscall.SIGTERM)
+	}
+	// Do not let descendants holding the output pipes open block the Wait() forever
+	cmd.WaitDelay = grace
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return proc, nil
+}
+
+type osProcess struct {
+	cmd *exec.Cmd
+
+	mu        sync.Mutex
+	killTimer *time.Timer
+	exited    bool
+}
+
+func (p *osProcess) killAfter(grace time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exited || p.killTimer != nil {
+		return
+	}
+
+	pgid := p.cmd.Process.Pid // Leader of its own process group
+	p.killTimer = time.AfterFunc(grace, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		// The group id can be reused once the leader is reaped
+		if p.exited {
+			return
+		}
+		Out(os.Stderr, color.RedString("Killing process group %d, not terminated within %s", pgid, grace))
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+	})
+}
+
+func (p *osProcess) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+func (p *osProcess) Signal(sig syscall.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// The group id can be reused once the leader is reaped
+	if p.exited {
+		return os.ErrProcessDone
+	}
+	return syscall.Kill(-p.cmd.Process.Pid, sig)
+}
+
+func (p *osProcess) Wait() (ExitStatus, error) {
+	err := p.cmd.Wait()
+
+	p.mu.Lock()
+	p.exited = true
+	if p.killTimer != nil {
+		p.killTimer.Stop()
+	}
+	p.mu.Unlock()
+
+	state := p.cmd.ProcessState
+	if state == nil {
+		return ExitStatus{Code: -1}, err
+	}
+
+	status := ExitStatus{
+		Code:       state.ExitCode(),
+		Orphans:    processGroupMembers(p.cmd.Process.Pid),
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		status.Signal = ws.Signal()
+	}
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		status.MaxRSS = rusage.Maxrss * 1024 // KiB on Linux
+	}
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return status, err
+	}
+	if exitErr != nil {
+		status.Err = exitErr
+	}
+	return status, nil
+}
+
+// DryRunExecutor skips the commands, reporting the details ManagedProc does not print itself.
+// All commands succeed with no output.
+type DryRunExecutor struct{}
+
+func (DryRunExecutor) Start(_ context.Context, spec *ExecSpec) (Process, error) {
+	msg := "  [dry-run] not executed"
+	if spec.Dir != "" {
+		msg += ", cwd: " + spec.Dir
+	}
+	if len(spec.Env) > 0 {
+		msg += ", env: " + strings.Join(spec.Env, " ")
+	}
+	Out(os.Stderr, color.CyanString(Redact(msg)))
+	return dryRunProcess{}, nil
+}
+
+// IsDryRun reports whether the commands are only described, see DryRunExecutor.
+// Workflows skip their other side effects too, like creating temporary files.
+func IsDryRun() bool {
+	_, dryRun := executor.(DryRunExecutor)
+	return dryRun
+}
+
+// tempPaths maps the temporary paths created by WriteTemp and MkdirTemp to names stable across runs, see stableTempPaths.
+var tempPaths = struct {
+	mu     sync.Mutex
+	stable map[string]string
+}{stable: map[string]string{}}
+
+// registerTempPath names the random path created from the pattern as $TMPDIR/PATTERN, with the random part removed.
+func registerTempPath(path string, pattern string) {
+	tempPaths.mu.Lock()
+	defer tempPaths.mu.Unlock()
+	tempPaths.stable[path] = filepath.Join("$TMPDIR", strings.Replace(pattern, "*", "", 1))
+}
+
+// stableTempPaths replaces the temporary paths in s with their stable names, so cassettes match the commands
+// referring to them, like config files, whatever run and machine created them.
+func stableTempPaths(s string) string {
+	tempPaths.mu.Lock()
+	defer tempPaths.mu.Unlock()
+	// Longest first, not to break the paths that others are a prefix of
+	paths := slices.SortedFunc(maps.Keys(tempPaths.stable), func(a, b string) int {
+		return len(b) - len(a)
+	})
+	for _, path := range paths {
+		s = strings.ReplaceAll(s, path, tempPaths.stable[path])
+	}
+	return s
+}
+
+// WriteTemp writes the content to a new temporary file, see os.CreateTemp, returning its path.
+// Under dry-run nothing is written, and the path is only for the described commands to refer to.
+func WriteTemp(pattern string, content []byte) (string, error) {
+	if IsDryRun() {
+		return dryRunTempPath(pattern), nil
+	}
+
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+	registerTempPath(file.Name(), pattern)
+	return file.Name(), nil
+}
+
+// MkdirTemp creates a new temporary directory, see os.MkdirTemp, returning its path.
+// Under dry-run nothing is created, and the path is only for the described commands to refer to.
+func MkdirTemp(pattern string) (string, error) {
+	if IsDryRun() {
+		return dryRunTempPath(pattern), nil
+	}
+	dir, err := os.MkdirTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	registerTempPath(dir, pattern)
+	return dir, nil
+}
+
+func dryRunTempPath(pattern string) string {
+	path := filepath.Join(os.TempDir(), strings.Replace(pattern, "*", "dry-run", 1))
+	Out(os.Stderr, color.CyanString("  [dry-run] not created: %s", path))
+	return path
+}
+
+type dryRunProcess struct{}
+
+func (dryRunProcess) Pid() int {
+	return 0
+}
+
+func (dryRunProcess) Signal(syscall.Signal) error {
+	return nil
+}
+
+func (dryRunProcess) Wait() (ExitStatus, error) {
+	return ExitStatus{}, nil
+}
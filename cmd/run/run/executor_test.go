@@ -0,0 +1,68 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTimeoutTerminates(t *testing.T) {
+	mp := NewManagedProc(t.Context(), "sleep", "30")
+	mp.Timeout = 200 * time.Millisecond
+
+	var procErr *ProcessError
+	if err := mp.Run(); !errors.As(err, &procErr) {
+		t.Fatalf("expected ProcessError, got %v", err)
+	}
+	if procErr.Signal != syscall.SIGTERM {
+		t.Errorf("expected terminated by SIGTERM, got %v", procErr.Signal)
+	}
+}
+
+func TestTimeoutKillsGroup(t *testing.T) {
+	// Both the shell and its child ignore SIGTERM
+	mp := NewManagedProc(t.Context(), "sh", "-c", "trap '' TERM; sleep 30 & echo $!; wait")
+	mp.Timeout = 200 * time.Millisecond
+	mp.GracePeriod = 300 * time.Millisecond
+	stdout := mp.CaptureStdout()
+
+	start := time.Now()
+	var procErr *ProcessError
+	if err := mp.Run(); !errors.As(err, &procErr) {
+		t.Fatalf("expected ProcessError, got %v", err)
+	}
+	if procErr.Signal != syscall.SIGKILL {
+		t.Errorf("expected killed by SIGKILL, got %v", procErr.Signal)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected killed after the grace period, took %s", elapsed)
+	}
+
+	child, err := strconv.Atoi(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		t.Fatalf("unexpected stdout %q", stdout)
+	}
+	if !processGone(child, time.Second) {
+		_ = syscall.Kill(child, syscall.SIGKILL)
+		t.Errorf("expected child %d killed with the group", child)
+	}
+}
+
+// processGone waits for the process to exit, left as a zombie until reaped by whoever inherited it.
+func processGone(pid int, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			return true
+		}
+		if _, fields, _ := strings.Cut(string(stat), ") "); strings.HasPrefix(fields, "Z") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// FakeExecutor serves scripted responses instead of running commands, so workflows can be tested without docker.
+type FakeExecutor struct {
+	mu          sync.Mutex
+	responses   []*fakeResponse
+	invocations []string
+	// stdins holds what the invocations were given on stdin
+	stdins []string
+}
+
+// FakeResponse is the scripted outcome of a command.
+type FakeResponse struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	// Times limits how many invocations the response serves, 0 for unlimited.
+	Times int
+}
+
+type fakeResponse struct {
+	pattern *regexp.Regexp
+	FakeResponse
+	served int
+}
+
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{}
+}
+
+// On scripts a response for commands whose space separated arguments match the pattern.
+// Responses are tried in the order they were registered, the first one matching and not exhausted is used.
+func (f *FakeExecutor) On(pattern string, response FakeResponse) *FakeExecutor {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, &fakeResponse{pattern: regexp.MustCompile(pattern), FakeResponse: response})
+	return f
+}
+
+// Invocations returns space separated arguments of all the commands started, in order.
+func (f *FakeExecutor) Invocations() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.invocations...)
+}
+
+// Stdins returns what the commands matching the pattern were given on stdin, in order.
+func (f *FakeExecutor) Stdins(pattern string) []string {
+	re := regexp.MustCompile(pattern)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var stdins []string
+	for i, invocation := range f.invocations {
+		if re.MatchString(invocation) {
+			stdins = append(stdins, f.stdins[i])
+		}
+	}
+	return stdins
+}
+
+// Count returns the number of commands started matching the pattern.
+func (f *FakeExecutor) Count(pattern string) int {
+	re := regexp.MustCompile(pattern)
+	count := 0
+	for _, invocation := range f.Invocations() {
+		if re.MatchString(invocation) {
+			count++
+		}
+	}
+	return count
+}
+
+func (f *FakeExecutor) Start(_ context.Context, spec *ExecSpec) (Process, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cmdline := strings.Join(spec.Args, " ")
+	f.invocations = append(f.invocations, cmdline)
+	stdin := ""
+	if spec.Stdin != nil {
+		in, err := io.ReadAll(spec.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		stdin = string(in)
+	}
+	f.stdins = append(f.stdins, stdin)
+	for _, response := range f.responses {
+		if response.Times > 0 && response.served >= response.Times {
+			continue
+		}
+		if response.pattern.MatchString(cmdline) {
+			response.served++
+			return &fakeProcess{spec, response.FakeResponse}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no fake response scripted for %q", cmdline)
+}
+
+type fakeProcess struct {
+	spec     *ExecSpec
+	response FakeResponse
+}
+
+func (p *fakeProcess) Pid() int {
+	return 0
+}
+
+func (p *fakeProcess) Signal(syscall.Signal) error {
+	return nil
+}
+
+func (p *fakeProcess) Wait() (ExitStatus, error) {
+	if _, err := io.WriteString(p.spec.Stdout, p.response.Stdout); err != nil {
+		return ExitStatus{}, err
+	}
+	if _, err := io.WriteString(p.spec.Stderr, p.response.Stderr); err != nil {
+		return ExitStatus{}, err
+	}
+	return ExitStatus{Code: p.response.ExitCode}, nil
+}
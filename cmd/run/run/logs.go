@@ -0,0 +1,130 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	logNameMaxLen = 80
+	logBackups    = 3
+)
+
+var (
+	// logDir is the per-run directory for ManagedProc logs, logging is disabled when empty
+	logDir     string
+	logMaxSize int64
+	logSeq     atomic.Int32
+
+	logNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// InitLogDir creates a per-run directory under base, where every ManagedProc writes its raw output.
+// Log files are rotated when they exceed maxSize bytes.
+func InitLogDir(base string, maxSize int64) (string, error) {
+	dir := filepath.Join(base, "run-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("failed creating log directory %s: %w", dir, err)
+	}
+
+	logDir = dir
+	logMaxSize = maxSize
+	return dir, nil
+}
+
+// openProcLog opens a log file for a process, or returns nil when logging is disabled.
+func openProcLog(label string) (*rotatingFile, error) {
+	if logDir == "" {
+		return nil, nil
+	}
+
+	name := fmt.Sprintf("%03d-%s.log", logSeq.Add(1), logFileName(label))
+	rf := &rotatingFile{path: filepath.Join(logDir, name), maxSize: logMaxSize}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// logFileName turns the process label, or (masked) command line, into a portable file name.
+func logFileName(label string) string {
+	name := strings.TrimPrefix(label, "$ ")
+	name = strings.Trim(logNameUnsafe.ReplaceAllString(name, "_"), "_.")
+	if len(name) > logNameMaxLen {
+		name = name[:logNameMaxLen]
+	}
+	return name
+}
+
+// rotatingFile is an append-only log file, that is moved aside to path.1, path.2, etc. once it exceeds maxSize.
+// Writes are serialized so both output streams of a process can share one file.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+var _ io.WriteCloser = &rotatingFile{}
+
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+
+	// The oldest backup gets overwritten
+	for i := logBackups - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+		return err
+	}
+
+	return rf.open()
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Close()
+}
@@ -0,0 +1,59 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogRotation(t *testing.T) {
+	dir, err := InitLogDir(t.TempDir(), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		logDir = ""
+		logMaxSize = 0
+	})
+
+	// The header goes first, and every line takes 4 bytes, 5 of them fit a file
+	mp := NewManagedProc(t.Context(), "sh", "-c", `for i in $(seq 1 20); do printf 'l%02d\n' $i; done`)
+	if err := mp.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("expected a single log, got %v %v", logs, err)
+	}
+	expected := map[string]string{
+		logs[0]:        "l16\nl17\nl18\nl19\nl20\n",
+		logs[0] + ".1": "l11\nl12\nl13\nl14\nl15\n",
+		logs[0] + ".2": "l06\nl07\nl08\nl09\nl10\n",
+		// The header went out of the oldest backup
+		logs[0] + ".3": "l01\nl02\nl03\nl04\nl05\n",
+	}
+	for path, content := range expected {
+		actual, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != content {
+			t.Errorf("expected %s to contain %q, got %q", filepath.Base(path), content, actual)
+		}
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s.%d", logs[0], logBackups+1)); !os.IsNotExist(err) {
+		t.Errorf("expected at most %d backups, got %v", logBackups, err)
+	}
+}
+
+func TestLogFileName(t *testing.T) {
+	if name := logFileName("$ kubectl -n argocd get secret/x"); name != "kubectl_-n_argocd_get_secret_x" {
+		t.Errorf("unexpected name %q", name)
+	}
+	if name := logFileName("$ " + strings.Repeat("a", 2*logNameMaxLen)); len(name) != logNameMaxLen {
+		t.Errorf("expected name truncated to %d, got %d", logNameMaxLen, len(name))
+	}
+}
@@ -2,196 +2,394 @@ package run
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/fatih/color"
 )
 
-// lineTransformer optionally mutates lines of a ManagedProc output.
-// Modified string will be printed, line is omitted if nil is returned.
-type lineTransformer func(in string) *string
-
 type ManagedProc struct {
-	args              []string
-	cmd               *exec.Cmd
-	StdoutTransformer lineTransformer
-	StderrTransformer lineTransformer
-	mask              []string
-
+	args  []string
+	dir   string
+	env   []string
+	stdin io.Reader
+	// Stdout and Stderr configure how the process outputs are processed and displayed.
+	Stdout *OutputStream
+	Stderr *OutputStream
+	// Restart configures if and how the process is started again after it exits.
+	Restart RestartPolicy
+	// Timeout terminates every attempt running longer, 0 for no timeout.
+	Timeout time.Duration
+	// GracePeriod overrides DefaultGracePeriod for the process to terminate before it is killed.
+	GracePeriod time.Duration
+	// Daemonizes tells the process leaves a descendant running on purpose, like xclip serving the selection.
+	// Its outputs are discarded, not to be held open by the descendant, and the descendant is not reported.
+	Daemonizes bool
+
+	probes        []ReadinessProbe
+	ready         chan struct{}
+	readinessOnce sync.Once
+	// done is closed when Run returns
+	done chan struct{}
+
+	ctx                context.Context
+	stop               context.CancelFunc
 	releaseContextTask func()
-	status             managedProcStatus
+	label              string
+	// log receives raw output of all the attempts, nil when logging is disabled
+	log io.Writer
+
+	// mu guards the runtime state below, that is read by the status reporter
+	mu       sync.Mutex
+	status   managedProcStatus
+	active   bool
+	proc     Process
+	pid      int
+	started  time.Time
+	exitCode int
+	usage    resourceUsage
 }
 
 type managedProcStatus = string
 
-func NewManagedProc(args ...string) *ManagedProc {
+// NewManagedProc creates a process interrupted when ctx is cancelled, and waited for by its task tracker.
+func NewManagedProc(ctx context.Context, args ...string) *ManagedProc {
+	mp := newManagedProc(args)
+	mp.releaseContextTask = useTask(ctx, "process-"+mp.visual())
+	mp.ctx, mp.stop = context.WithCancel(ctx)
+	return mp
+}
+
+// NewCleanupProc creates a ManagedProc that is neither interrupted, nor waited for, on signal.
+// It is meant for releasing resources after the main context is cancelled, so it runs within ctx of a CleanupHook instead.
+func NewCleanupProc(ctx context.Context, args ...string) *ManagedProc {
+	mp := newManagedProc(args)
+	mp.ctx, mp.stop = context.WithCancel(ctx)
+	// Not waited for, but reported when it is what blocks the shutdown
+	mp.releaseContextTask = reportTask(ctx, "cleanup-"+mp.visual())
+	return mp
+}
+
+func newManagedProc(args []string) *ManagedProc {
 	mp := &ManagedProc{
-		args: args,
+		args:     args,
+		Stdout:   newOutputStream(os.Stdout),
+		Stderr:   newOutputStream(os.Stderr),
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+		exitCode: -1,
 	}
 	mp.update("new") // Set status this way so the transition is logged
+	return mp
+}
 
-	command := args[0]
-	args = args[1:]
-
-	var ctx context.Context
-	ctx, mp.releaseContextTask = MainTt.UseContext("process-" + mp.visual())
-	mp.cmd = exec.CommandContext(ctx, command, args...)
-
-	// Start all children processes in one process group to deliver the SIGTERM in one go.
-	// This speeds up termination of goreman significantly.
-	mp.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+func (mp *ManagedProc) Dir(cwd string) {
+	mp.dir = cwd
+}
 
-	// Replace default handler from exec.CommandContext, use SIGTERM over SIGKILL.
-	mp.cmd.Cancel = func() error {
-		err := mp.cmd.Process.Signal(syscall.SIGTERM)
-		if err != nil {
-			return err
-		}
-		err = mp.cmd.Process.Signal(syscall.SIGTERM)
-		if err != nil {
-			return err
-		}
-		return nil
-	}
-	return mp
+// Label names the process in place of its command line, where a short name is needed.
+func (mp *ManagedProc) Label(label string) {
+	mp.label = label
 }
 
-func (mp *ManagedProc) CaptureStdout() *bytes.Buffer {
-	buffer := new(bytes.Buffer)
-	mp.StdoutTransformer = func(in string) *string {
-		buffer.WriteString(in)
-		buffer.WriteString("\n")
-		return nil
-	}
-	return buffer
+// Stop terminates the process, if running, and prevents its further restarts.
+func (mp *ManagedProc) Stop() {
+	mp.stop()
 }
 
-func (mp *ManagedProc) Dir(cwd string) {
-	mp.cmd.Dir = cwd
+func (mp *ManagedProc) Stdin(in io.Reader) {
+	mp.stdin = in
 }
 
+// Mask registers a secret the process works with, see RegisterSecret.
 func (mp *ManagedProc) Mask(private string) {
-	mp.mask = append(mp.mask, private)
+	RegisterSecret(private)
 }
 
 func (mp *ManagedProc) AddEnv(key string, value string) {
-	if mp.cmd.Env == nil {
-		mp.cmd.Env = os.Environ()
-	}
-	mp.cmd.Env = append(mp.cmd.Env, key+"="+value)
+	mp.env = append(mp.env, key+"="+value)
 }
 
 func (mp *ManagedProc) Run() error {
-	Out(os.Stderr, color.GreenString(mp.visual()))
-
-	outputsWritten, err := mp.pumpOutputs()
-	if err != nil {
-		return err
-	}
-
-	mp.update("running")
-	// Keep waiting for as long as cmd.Run() is running
+	// Keep waiting for as long as the process, or its restarts, are running
+	mp.setActive(true)
+	procRegistry.add(mp)
 	defer func() {
+		mp.setActive(false)
+		procRegistry.remove(mp)
+		close(mp.done)
+		mp.stop()
 		mp.releaseContextTask()
 	}()
 
-	err = mp.cmd.Run()
+	logName := mp.label
+	if logName == "" {
+		logName = mp.visual()
+	}
+	logFile, err := openProcLog(logName)
 	if err != nil {
-		mp.update(fmt.Sprintf("failed(%s)", err.Error()))
-		return fmt.Errorf("failed: %s", err)
+		return fmt.Errorf("failed opening log file: %w", err)
+	}
+	if logFile != nil {
+		defer logFile.Close()
+		mp.log = logFile
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := mp.runOnce(attempt)
+		if !mp.Restart.shouldRestart(mp.ctx, err, attempt) {
+			return err
+		}
+
+		delay := mp.Restart.backoff(attempt)
+		mp.update(fmt.Sprintf("restarting(%d/%s in %s)", attempt, mp.Restart.retriesVisual(), delay))
+		select {
+		case <-time.After(delay):
+		case <-mp.ctx.Done():
+			return err
+		}
+	}
+}
+
+func (mp *ManagedProc) runOnce(attempt int) error {
+	Out(os.Stderr, color.GreenString(mp.visual()))
+	if mp.log != nil {
+		_, _ = fmt.Fprintf(mp.log, "### %s %s\n", time.Now().Format(time.RFC3339), mp.visual())
+	}
+
+	outPipe, outWriter := io.Pipe()
+	errPipe, errWriter := io.Pipe()
+	stderrTail := newLineTail(stderrTailLines)
+	outputsWritten := mp.pumpOutputs(outPipe, errPipe, stderrTail)
+
+	ctx := mp.ctx
+	if mp.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mp.Timeout)
+		defer cancel()
 	}
 
-	mp.update("flushing-outs")
+	var stdout, stderr io.Writer = outWriter, errWriter
+	if mp.Daemonizes {
+		// A file is passed to the process as is, unlike writers copied from pipes until all their holders exit
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			return fmt.Errorf("failed opening %s: %w", os.DevNull, err)
+		}
+		defer devNull.Close()
+		stdout, stderr = devNull, devNull
+	}
 
+	start := time.Now()
+	proc, err := executor.Start(ctx, &ExecSpec{
+		Args:        mp.args,
+		Dir:         mp.dir,
+		Env:         mp.env,
+		Stdin:       mp.stdin,
+		Stdout:      stdout,
+		Stderr:      stderr,
+		GracePeriod: mp.GracePeriod,
+
+		SensitiveStdout: mp.Stdout.sensitive,
+		SensitiveStderr: mp.Stderr.sensitive,
+	})
+	status := ExitStatus{Code: -1}
+	if err == nil {
+		mp.setStarted(proc)
+		mp.update("running")
+		mp.emit(Event{Type: EventProcessStarted, Pid: proc.Pid(), Attempt: attempt})
+		mp.readinessOnce.Do(func() {
+			go mp.awaitReadiness()
+		})
+
+		status, err = proc.Wait()
+		mp.setExited(status)
+		if len(status.Orphans) > 0 && !mp.Daemonizes {
+			Out(os.Stderr, color.YellowString("Processes %v left running after %s exited", status.Orphans, mp.visual()))
+		}
+	}
+	// All output is written once the process is waited for, let the pumps drain
+	_ = outWriter.Close()
+	_ = errWriter.Close()
+
+	if err == nil && !status.Success() {
+		err = status.Err
+		if err == nil {
+			err = errors.New(status.String())
+		}
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s: %w", mp.Timeout, err)
+	}
+	if err == nil {
+		mp.update("flushing-outs")
+	}
 	outputsWritten.Wait()
+	mp.emitExited(attempt, status, time.Since(start), err)
+
+	if err != nil {
+		mp.update(fmt.Sprintf("failed(%s)", err.Error()))
+		return mp.newError(err, status, time.Since(start), stderrTail)
+	}
 
 	mp.update("completed")
 
 	return nil
 }
 
-func (mp *ManagedProc) pumpOutputs() (*sync.WaitGroup, error) {
+func (mp *ManagedProc) emit(event Event) {
+	event.Command = strings.Join(mp.args, " ")
+	event.Label = mp.label
+	Emit(event)
+}
+
+func (mp *ManagedProc) emitExited(attempt int, status ExitStatus, duration time.Duration, err error) {
+	event := Event{Type: EventProcessExited, Attempt: attempt, Duration: duration}
+	if status.Code >= 0 {
+		event.ExitCode = &status.Code
+	}
+	if status.Signal != 0 {
+		event.Signal = status.Signal.String()
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	mp.emit(event)
+}
+
+func (mp *ManagedProc) newError(err error, status ExitStatus, duration time.Duration, stderrTail *lineTail) *ProcessError {
+	var args []string
+	for _, arg := range mp.args {
+		args = append(args, Redact(arg))
+	}
+	return &ProcessError{
+		Args:       args,
+		ExitCode:   status.Code,
+		Signal:     status.Signal,
+		Duration:   duration,
+		StderrTail: stderrTail.get(),
+		Err:        err,
+	}
+}
+
+func (mp *ManagedProc) pumpOutputs(outPipe io.ReadCloser, errPipe io.ReadCloser, stderrTail *lineTail) *sync.WaitGroup {
 	var wg sync.WaitGroup
 	wg.Add(2)
-	outPipe, err := mp.cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
+	observe := func(in string) *string {
+		mp.observeLine(in)
+		return &in
 	}
-	errPipe, err := mp.cmd.StderrPipe()
-	if err != nil {
-		return nil, err
+	logged := func(stream *OutputStream) []LineSink {
+		if mp.log == nil || stream.sensitive {
+			return nil
+		}
+		return []LineSink{writerSink(mp.log)}
 	}
-	outPump := &streamPump{outPipe, os.Stdout, mp.StdoutTransformer, &wg}
-	errPump := &streamPump{errPipe, os.Stderr, mp.StderrTransformer, &wg}
+	tail := func(in string) *string {
+		stderrTail.add(in)
+		return &in
+	}
+	outPump := &streamPump{outPipe, mp.Stdout.pipeline([]LineSink{observe}, logged(mp.Stdout)), &wg}
+	errPump := &streamPump{errPipe, mp.Stderr.pipeline([]LineSink{observe}, append(logged(mp.Stderr), tail)), &wg}
 	go outPump.pump()
 	go errPump.pump()
-	return &wg, err
+	return &wg
 }
 
 func (mp *ManagedProc) String() string {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
 	return fmt.Sprintf("%v: %s", mp.status, mp.visual())
 }
 
 func (mp *ManagedProc) update(status managedProcStatus) {
+	mp.mu.Lock()
 	mp.status = status
+	mp.mu.Unlock()
+
+	// Report transitions of restartable processes, so it is clear why the same command shows up repeatedly
+	if mp.Restart.Policy != RestartNever {
+		Out(os.Stderr, color.YellowString(mp.String()))
+	}
 }
 
-func (mp *ManagedProc) visual() string {
-	cmdline := strings.Join(mp.args, " ")
-	for _, secret := range mp.mask {
-		cmdline = strings.ReplaceAll(cmdline, secret, "*REDACTED*")
+func (mp *ManagedProc) setActive(active bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.active = active
+}
+
+func (mp *ManagedProc) setStarted(proc Process) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.proc = proc
+	mp.pid = proc.Pid()
+	mp.started = time.Now()
+}
+
+func (mp *ManagedProc) setExited(status ExitStatus) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.proc = nil
+	mp.exitCode = status.Code
+	mp.usage.add(time.Since(mp.started), status)
+}
+
+// Signal delivers the signal to the running process and all its descendants, so they can reload for example.
+func (mp *ManagedProc) Signal(sig syscall.Signal) error {
+	mp.mu.Lock()
+	proc := mp.proc
+	mp.mu.Unlock()
+
+	if proc == nil {
+		return fmt.Errorf("not running: %s", mp.visual())
 	}
+	return proc.Signal(sig)
+}
 
+func (mp *ManagedProc) visual() string {
+	cmdline := Redact(strings.Join(mp.args, " "))
 	return fmt.Sprintf("$ %s", cmdline)
 }
 
 type streamPump struct {
-	reader      io.ReadCloser
-	writer      io.Writer
-	transformer lineTransformer
-	done        *sync.WaitGroup
+	reader io.ReadCloser
+	sinks  []LineSink
+	done   *sync.WaitGroup
 }
 
 func (sp *streamPump) pump() {
 	defer sp.done.Done() // Report when all output is processed
 
-	// Noop if nil transformer configured
-	if sp.transformer == nil {
-		sp.transformer = func(s string) *string { return &s }
-	}
-
 	rd := bufio.NewReader(sp.reader)
-	lastLine := false
 	for {
 		inLine, err := rd.ReadString('\n')
+		if inLine != "" {
+			sp.write(inLine)
+		}
 		if err != nil {
 			// The upstream process completed
 			if err == io.EOF || errors.Is(err, os.ErrClosed) {
-				lastLine = true
-			} else {
-				panic(err)
-			}
-		}
-
-		outLine := sp.transformer(inLine)
-		if outLine != nil {
-			_, err = fmt.Fprint(sp.writer, *outLine)
-			if err != nil {
-				panic(err)
+				return
 			}
+			panic(err)
 		}
+	}
+}
 
-		if lastLine {
-			break
+func (sp *streamPump) write(line string) {
+	for _, sink := range sp.sinks {
+		out := sink(line)
+		if out == nil {
+			return
 		}
+		line = *out
 	}
 }
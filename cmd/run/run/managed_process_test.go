@@ -0,0 +1,21 @@
+package run
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDaemonizingProc(t *testing.T) {
+	// The background sleep inherits the outputs, like the xclip child serving the selection
+	mp := NewManagedProc(t.Context(), "sh", "-c", "sleep 3 & echo started")
+	mp.GracePeriod = 500 * time.Millisecond
+	mp.Daemonizes = true
+
+	start := time.Now()
+	if err := mp.Run(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > mp.GracePeriod {
+		t.Errorf("expected not waiting for the background process, took %s", elapsed)
+	}
+}
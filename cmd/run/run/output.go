@@ -0,0 +1,140 @@
+package run
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// LineSink is a stage of an OutputStream pipeline, it receives lines including their line endings.
+// The returned line is passed to the next stage, nil stops the line from propagating further.
+type LineSink func(in string) *string
+
+// OutputStream configures what happens with lines of one of the ManagedProc outputs.
+// Every line passes the stages in this order:
+//   - captures, getting the raw line,
+//   - secret masker, see Redact,
+//   - tees, getting the masked line,
+//   - transformer chain, that can modify or drop the line,
+//   - display, unless silenced.
+//
+// Secrets are masked in everything except for captures, as those are consumed as data.
+type OutputStream struct {
+	captures     []io.Writer
+	tees         []io.Writer
+	transformers []LineSink
+	display      io.Writer
+	// sensitive streams are kept out of the log file and the cassette
+	sensitive bool
+}
+
+func newOutputStream(display io.Writer) *OutputStream {
+	return &OutputStream{display: display}
+}
+
+// Capture writes raw lines to w, before secrets are masked.
+func (s *OutputStream) Capture(w io.Writer) {
+	s.captures = append(s.captures, w)
+}
+
+// Tee writes lines to w, after secrets are masked and before they are transformed.
+func (s *OutputStream) Tee(w io.Writer) {
+	s.tees = append(s.tees, w)
+}
+
+// Transform appends the transformer to the chain, nil transformer is ignored.
+func (s *OutputStream) Transform(transformer LineSink) {
+	if transformer != nil {
+		s.transformers = append(s.transformers, transformer)
+	}
+}
+
+// Silence stops displaying the stream, without affecting the rest of the pipeline.
+func (s *OutputStream) Silence() {
+	s.display = nil
+}
+
+// Sensitive keeps the stream out of the process log file and the recorded cassette, for data that masking cannot catch,
+// like credentials, or secrets read before they can be registered. Captures still get all of it, and replay serves
+// a placeholder that decodes as base64.
+func (s *OutputStream) Sensitive() {
+	s.sensitive = true
+}
+
+// pipeline composes the stages, with raw and masked sinks used internally added to captures and tees respectively.
+func (s *OutputStream) pipeline(raw []LineSink, masked []LineSink) []LineSink {
+	var sinks []LineSink
+	sinks = append(sinks, raw...)
+	for _, w := range s.captures {
+		sinks = append(sinks, writerSink(w))
+	}
+	sinks = append(sinks, maskSink)
+	sinks = append(sinks, masked...)
+	for _, w := range s.tees {
+		sinks = append(sinks, writerSink(w))
+	}
+	sinks = append(sinks, s.transformers...)
+	if s.display != nil {
+		sinks = append(sinks, displaySink(s.display))
+	}
+	return sinks
+}
+
+func maskSink(in string) *string {
+	out := Redact(in)
+	return &out
+}
+
+// writerSink passes the line through, as failing to capture or tee it should not take the process down.
+func writerSink(w io.Writer) LineSink {
+	return func(in string) *string {
+		_, _ = io.WriteString(w, in)
+		return &in
+	}
+}
+
+func displaySink(w io.Writer) LineSink {
+	return func(in string) *string {
+		if _, err := fmt.Fprint(w, in); err != nil {
+			panic(err)
+		}
+		return nil
+	}
+}
+
+// CaptureStdout collects raw stdout, hiding it from display as it is consumed as data.
+func (mp *ManagedProc) CaptureStdout() *bytes.Buffer {
+	buffer := new(bytes.Buffer)
+	mp.Stdout.Capture(buffer)
+	mp.Stdout.Silence()
+	return buffer
+}
+
+// CaptureStderr collects raw stderr, that is still displayed.
+func (mp *ManagedProc) CaptureStderr() *bytes.Buffer {
+	buffer := new(bytes.Buffer)
+	mp.Stderr.Capture(buffer)
+	return buffer
+}
+
+// CaptureCombined collects raw stdout and stderr interleaved in a single buffer, both still displayed.
+func (mp *ManagedProc) CaptureCombined() *bytes.Buffer {
+	buffer := new(bytes.Buffer)
+	// Streams are pumped concurrently
+	shared := &lockedWriter{w: buffer}
+	mp.Stdout.Capture(shared)
+	mp.Stderr.Capture(shared)
+	return buffer
+}
+
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
@@ -0,0 +1,104 @@
+package run
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputStreamPipeline(t *testing.T) {
+	RegisterSecret("t0p-s3cret")
+	mp := NewManagedProc(t.Context(), "sh", "-c", "echo out t0p-s3cret; echo err >&2")
+
+	raw := mp.CaptureStdout()
+	var teed, displayed bytes.Buffer
+	mp.Stdout.Tee(&teed)
+	mp.Stdout.Transform(func(in string) *string {
+		out := strings.ToUpper(in)
+		return &out
+	})
+	mp.Stdout.display = &displayed // Silenced by CaptureStdout
+	stderr := mp.CaptureStderr()
+	mp.Stderr.Silence()
+
+	if err := mp.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if raw.String() != "out t0p-s3cret\n" {
+		t.Errorf("expected raw capture, got %q", raw)
+	}
+	if teed.String() != "out *REDACTED*\n" {
+		t.Errorf("expected masked tee, got %q", teed)
+	}
+	if displayed.String() != "OUT *REDACTED*\n" {
+		t.Errorf("expected masked and transformed display, got %q", displayed)
+	}
+	if stderr.String() != "err\n" {
+		t.Errorf("expected stderr captured, got %q", stderr)
+	}
+}
+
+func TestCaptureCombined(t *testing.T) {
+	mp := NewManagedProc(t.Context(), "sh", "-c", "echo out; echo err >&2")
+	combined := mp.CaptureCombined()
+	mp.Stdout.Silence()
+	mp.Stderr.Silence()
+
+	if err := mp.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Order of the streams is not guaranteed
+	lines := strings.Split(strings.TrimSpace(combined.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(combined.String(), "out\n") || !strings.Contains(combined.String(), "err\n") {
+		t.Errorf("expected both streams combined, got %q", combined)
+	}
+}
+
+func TestSensitiveOutput(t *testing.T) {
+	if _, err := InitLogDir(t.TempDir(), 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		logDir = ""
+	})
+	cassette := t.TempDir() + "/cassette.jsonl"
+	recorder, err := NewRecordingExecutor(t.Context(), OsExecutor{}, cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseExecutor(t, recorder)
+	t.Cleanup(func() {
+		cleanups.hooks = nil
+	})
+
+	mp := NewManagedProc(t.Context(), "sh", "-c", "printf '%s-%s\\n' client key; echo visible >&2")
+	stdout := mp.CaptureStdout()
+	mp.Stdout.Sensitive()
+	if err := mp.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout.String() != "client-key\n" {
+		t.Errorf("expected sensitive stdout captured, got %q", stdout)
+	}
+	logs, err := filepath.Glob(filepath.Join(logDir, "*.log"))
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("expected one log file, got %q: %v", logs, err)
+	}
+	for _, file := range append(logs, cassette) {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(content), "client-key") {
+			t.Errorf("sensitive output persisted in %s: %s", file, content)
+		}
+		if !strings.Contains(string(content), "visible") {
+			t.Errorf("expected the other output persisted in %s: %s", file, content)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"time"
+)
+
+const (
+	defaultPollTimeout     = 10 * time.Minute
+	defaultPollInterval    = 2 * time.Second
+	defaultPollMaxInterval = 15 * time.Second
+	defaultPollJitter      = 0.2
+)
+
+// Poller repeats a check until it succeeds, the Timeout elapses, or the context is cancelled.
+// The delay between checks starts at Interval and doubles with every attempt, up to MaxInterval.
+type Poller struct {
+	// Description of what is waited for, used in progress messages and errors.
+	Description string
+	// Timeout bounds the overall wait, 10 minutes by default.
+	Timeout     time.Duration
+	Interval    time.Duration
+	MaxInterval time.Duration
+	// Jitter randomizes each delay by up to the fraction of it, 0.2 by default, negative to disable.
+	Jitter float64
+}
+
+type stopPolling struct {
+	err error
+}
+
+func (s *stopPolling) Error() string {
+	return s.err.Error()
+}
+
+func (s *stopPolling) Unwrap() error {
+	return s.err
+}
+
+// StopPolling makes Poll return the error right away, instead of checking again.
+func StopPolling(err error) error {
+	return &stopPolling{err}
+}
+
+// Poll calls check until it returns nil. Errors are reported as progress, unless wrapped by StopPolling.
+// The last error is returned when the Poller times out, or is interrupted.
+func (p Poller) Poll(ctx context.Context, check func() error) error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultPollTimeout
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		var stop *stopPolling
+		if errors.As(err, &stop) {
+			return stop.err
+		}
+
+		Out(os.Stderr, "Waiting for %s (%s): %s", p.Description, time.Since(start).Round(time.Second), err)
+		select {
+		case <-time.After(p.delay(attempt)):
+		case <-deadline.C:
+			return fmt.Errorf("timed out after %s waiting for %s: %w", timeout, p.Description, err)
+		case <-ctx.Done():
+			return fmt.Errorf("interrupted waiting for %s: %w", p.Description, ctx.Err())
+		}
+	}
+}
+
+// delay computes the pause after the attempt-th check.
+func (p Poller) delay(attempt int) time.Duration {
+	delay := p.Interval
+	if delay <= 0 {
+		delay = defaultPollInterval
+	}
+	maxDelay := p.MaxInterval
+	if maxDelay <= 0 {
+		maxDelay = defaultPollMaxInterval
+	}
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+
+	jitter := p.Jitter
+	if jitter == 0 {
+		jitter = defaultPollJitter
+	}
+	if jitter > 0 {
+		// Spread the checks, so parallel pollers do not hit the same resources in lockstep
+		delay += time.Duration((rand.Float64()*2 - 1) * jitter * float64(delay))
+	}
+	return delay
+}
@@ -0,0 +1,62 @@
+package run
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPoll(t *testing.T) {
+	attempts := 0
+	poller := Poller{Description: "test", Interval: time.Millisecond}
+	err := poller.Poll(t.Context(), func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("expected success on third attempt, got %d: %v", attempts, err)
+	}
+}
+
+func TestPollTimeout(t *testing.T) {
+	pending := errors.New("pending")
+	poller := Poller{Description: "test", Timeout: 20 * time.Millisecond, Interval: time.Millisecond, MaxInterval: 5 * time.Millisecond}
+	err := poller.Poll(t.Context(), func() error {
+		return pending
+	})
+	if !errors.Is(err, pending) {
+		t.Errorf("expected last error wrapped on timeout, got %v", err)
+	}
+}
+
+func TestPollStop(t *testing.T) {
+	fatal := errors.New("fatal")
+	attempts := 0
+	poller := Poller{Description: "test", Interval: time.Millisecond}
+	err := poller.Poll(t.Context(), func() error {
+		attempts++
+		return StopPolling(fatal)
+	})
+	if err != fatal || attempts != 1 {
+		t.Errorf("expected to stop after first attempt, got %d: %v", attempts, err)
+	}
+}
+
+func TestPollDelay(t *testing.T) {
+	poller := Poller{Interval: time.Second, MaxInterval: 3 * time.Second, Jitter: -1}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if delay := poller.delay(attempt + 1); delay != expected {
+			t.Errorf("attempt %d: expected %s, got %s", attempt+1, expected, delay)
+		}
+	}
+
+	poller.Jitter = 0.5
+	for range 100 {
+		if delay := poller.delay(1); delay < 500*time.Millisecond || delay > 1500*time.Millisecond {
+			t.Fatalf("delay out of jitter range: %s", delay)
+		}
+	}
+}
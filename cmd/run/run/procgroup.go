@@ -0,0 +1,46 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// processGroupMembers lists live processes in the process group, as a way to detect descendants
+// outliving their leader. Returns nil when there are none, or they cannot be detected.
+func processGroupMembers(pgid int) []int {
+	// Cheap check if anything is left in the group
+	if err := syscall.Kill(-pgid, 0); err != nil {
+		return nil
+	}
+
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil
+	}
+
+	var members []int
+	for _, stat := range stats {
+		content, err := os.ReadFile(stat)
+		if err != nil {
+			continue // Process completed in the meantime
+		}
+
+		// Fields after the command name in parentheses: state ppid pgrp ...
+		_, fields, found := strings.Cut(string(content), ") ")
+		if !found {
+			continue
+		}
+		split := strings.Fields(fields)
+		if len(split) < 3 || split[0] == "Z" {
+			continue
+		}
+		if pgrp, err := strconv.Atoi(split[2]); err == nil && pgrp == pgid {
+			pid, _ := strconv.Atoi(filepath.Base(filepath.Dir(stat)))
+			members = append(members, pid)
+		}
+	}
+	return members
+}
@@ -0,0 +1,86 @@
+package run
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startGroup starts the script in its own process group, returning the pid it prints first.
+func startGroup(t *testing.T, script string) (Process, int) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	proc, err := OsExecutor{}.Start(t.Context(), &ExecSpec{Args: []string{"sh", "-c", script}, Stdout: writer})
+	writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = syscall.Kill(-proc.Pid(), syscall.SIGKILL)
+	})
+
+	line, err := bufio.NewReader(reader).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("unexpected pid %q", line)
+	}
+	return proc, pid
+}
+
+func TestSignalGroup(t *testing.T) {
+	proc, child := startGroup(t, "sleep 30 & echo $!; wait")
+
+	if err := proc.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	status, err := proc.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Signal != syscall.SIGTERM {
+		t.Errorf("expected terminated by SIGTERM, got %v", status.Signal)
+	}
+	if len(status.Orphans) > 0 {
+		t.Errorf("expected no orphans, got %v", status.Orphans)
+	}
+	if !processGone(child, time.Second) {
+		t.Errorf("expected child %d terminated with the group", child)
+	}
+
+	if err := proc.Signal(syscall.SIGTERM); !errors.Is(err, os.ErrProcessDone) {
+		t.Errorf("expected no signals once exited, got %v", err)
+	}
+}
+
+func TestOrphanDetection(t *testing.T) {
+	proc, child := startGroup(t, "sleep 30 >/dev/null & echo $!")
+
+	status, err := proc.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(status.Orphans, []int{child}) {
+		t.Errorf("expected orphan %d, got %v", child, status.Orphans)
+	}
+
+	_ = syscall.Kill(-proc.Pid(), syscall.SIGKILL)
+	if !processGone(child, time.Second) {
+		t.Fatalf("expected child %d killed", child)
+	}
+	if members := processGroupMembers(proc.Pid()); members != nil {
+		t.Errorf("expected no members left, got %v", members)
+	}
+}
@@ -0,0 +1,163 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	probeInterval = 500 * time.Millisecond
+	probeTimeout  = 2 * time.Second
+)
+
+// ReadinessProbe detects a ManagedProc is ready to serve. Probes are created by LogProbe, TCPProbe and HTTPProbe.
+type ReadinessProbe interface {
+	String() string
+	// await blocks until the probe passes, or the ctx is done.
+	await(ctx context.Context) error
+}
+
+// LogProbe passes once the process prints a line matching the pattern, to either of its outputs.
+func LogProbe(pattern string) ReadinessProbe {
+	return &logProbe{pattern: regexp.MustCompile(pattern), matched: make(chan struct{})}
+}
+
+type logProbe struct {
+	pattern *regexp.Regexp
+	matched chan struct{}
+	once    sync.Once
+}
+
+func (p *logProbe) String() string {
+	return fmt.Sprintf("log line matching %q", p.pattern)
+}
+
+func (p *logProbe) observe(line string) {
+	if p.pattern.MatchString(line) {
+		p.once.Do(func() {
+			close(p.matched)
+		})
+	}
+}
+
+func (p *logProbe) await(ctx context.Context) error {
+	select {
+	case <-p.matched:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TCPProbe passes once the address accepts connections.
+func TCPProbe(address string) ReadinessProbe {
+	return &pollingProbe{"TCP " + address, func() bool {
+		conn, err := net.DialTimeout("tcp", address, probeTimeout)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}}
+}
+
+// HTTPProbe passes once GET of the url responds with 2xx status.
+func HTTPProbe(url string) ReadinessProbe {
+	client := &http.Client{Timeout: probeTimeout}
+	return &pollingProbe{"HTTP " + url, func() bool {
+		resp, err := client.Get(url)
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode >= 200 && resp.StatusCode < 300
+	}}
+}
+
+type pollingProbe struct {
+	description string
+	check       func() bool
+}
+
+func (p *pollingProbe) String() string {
+	return p.description
+}
+
+func (p *pollingProbe) await(ctx context.Context) error {
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for {
+		if p.check() {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// AddReadinessProbe adds a probe that must pass for the process to be considered ready.
+// Process with no probes is ready as soon as it starts.
+func (mp *ManagedProc) AddReadinessProbe(probe ReadinessProbe) {
+	mp.probes = append(mp.probes, probe)
+}
+
+// Ready returns a channel closed once all the readiness probes passed.
+func (mp *ManagedProc) Ready() <-chan struct{} {
+	return mp.ready
+}
+
+// WaitReady blocks until the process is ready, or fails when it exits, is interrupted, or the timeout expires.
+func (mp *ManagedProc) WaitReady(timeout time.Duration) error {
+	select {
+	case <-mp.ready:
+		return nil
+	case <-mp.done:
+		return fmt.Errorf("exited before ready: %s", mp.visual())
+	case <-mp.ctx.Done():
+		return fmt.Errorf("interrupted waiting for ready: %s", mp.visual())
+	case <-time.After(timeout):
+		return fmt.Errorf("not ready within %s: %s", timeout, mp.visual())
+	}
+}
+
+// awaitReadiness runs the probes until they all pass, or the process is done.
+func (mp *ManagedProc) awaitReadiness() {
+	ctx, cancel := context.WithCancel(mp.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-mp.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for _, probe := range mp.probes {
+		if err := probe.await(ctx); err != nil {
+			return
+		}
+	}
+
+	close(mp.ready)
+	if len(mp.probes) > 0 {
+		mp.update("ready")
+		mp.emit(Event{Type: EventProcessReady})
+	}
+}
+
+// observeLine feeds output lines to log probes.
+func (mp *ManagedProc) observeLine(line string) {
+	for _, probe := range mp.probes {
+		if lp, ok := probe.(*logProbe); ok {
+			lp.observe(line)
+		}
+	}
+}
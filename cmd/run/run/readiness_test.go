@@ -0,0 +1,65 @@
+package run
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLogProbe(t *testing.T) {
+	mp := NewManagedProc(t.Context(), "sh", "-c", "echo starting; sleep 0.2; echo listening on 8080 >&2; sleep 5")
+	mp.Timeout = 2 * time.Second
+	mp.AddReadinessProbe(LogProbe(`listening on \d+`))
+	runInBackground(t, mp)
+
+	if err := mp.WaitReady(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTCPProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	mp := NewManagedProc(t.Context(), "sleep", "5")
+	mp.Timeout = 2 * time.Second
+	mp.AddReadinessProbe(TCPProbe(listener.Addr().String()))
+	runInBackground(t, mp)
+
+	if err := mp.WaitReady(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExitedBeforeReady(t *testing.T) {
+	mp := NewManagedProc(t.Context(), "sh", "-c", "echo nothing to see")
+	mp.AddReadinessProbe(LogProbe(`never printed`))
+	go func() {
+		_ = mp.Run()
+	}()
+
+	if err := mp.WaitReady(5 * time.Second); err == nil {
+		t.Fatal("expected process not to become ready")
+	}
+	select {
+	case <-mp.Ready():
+		t.Fatal("ready channel closed for process that never became ready")
+	default:
+	}
+}
+
+// runInBackground runs the process until the test ends, so it does not outlive the test.
+func runInBackground(t *testing.T, mp *ManagedProc) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = mp.Run()
+	}()
+	t.Cleanup(func() {
+		mp.Stop()
+		<-done
+	})
+}
@@ -0,0 +1,157 @@
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// reportTableRows limits the printed report to the most time-consuming processes, the JSON has them all.
+const reportTableRows = 15
+
+var (
+	reportJSONPath string
+	reportOnce     sync.Once
+)
+
+// resourceUsage accumulates resources consumed by all the attempts of a ManagedProc.
+type resourceUsage struct {
+	runs       int
+	wall       time.Duration
+	userTime   time.Duration
+	systemTime time.Duration
+	maxRSS     int64
+}
+
+func (ru *resourceUsage) add(wall time.Duration, status ExitStatus) {
+	ru.runs++
+	ru.wall += wall
+	ru.userTime += status.UserTime
+	ru.systemTime += status.SystemTime
+	ru.maxRSS = max(ru.maxRSS, status.MaxRSS)
+}
+
+// ResourceReportEntry describes the resources consumed by a ManagedProc, including all its restarts.
+// The finished processes of the same command are reported as one entry.
+type ResourceReportEntry struct {
+	Command    string        `json:"command"`
+	Runs       int           `json:"runs"`
+	ExitCode   int           `json:"exitCode"`
+	Wall       time.Duration `json:"wallNs"`
+	UserTime   time.Duration `json:"userNs"`
+	SystemTime time.Duration `json:"systemNs"`
+	MaxRSS     int64         `json:"maxRssBytes"`
+}
+
+// SetResourceReportJSON makes ReportResources also write the report as JSON to the path.
+func SetResourceReportJSON(path string) {
+	reportJSONPath = path
+}
+
+// ReportResources prints resources consumed by the processes, most time-consuming first.
+// It reports once per run, so it can be called from all the exit paths.
+func ReportResources() {
+	reportOnce.Do(func() {
+		entries := procRegistry.resourceReport()
+		if len(entries) > 0 {
+			Out(os.Stderr, "%s", resourceTable(entries))
+		}
+
+		if reportJSONPath != "" {
+			if err := writeResourceReport(reportJSONPath, entries); err != nil {
+				Out(os.Stderr, "Failed writing resource report: %s", err)
+			}
+		}
+	})
+}
+
+// reportEntry describes the resources consumed by the process so far, false if it has not run yet.
+func (mp *ManagedProc) reportEntry() (ResourceReportEntry, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return ResourceReportEntry{
+		Command:    mp.visual(),
+		Runs:       mp.usage.runs,
+		ExitCode:   mp.exitCode,
+		Wall:       mp.usage.wall,
+		UserTime:   mp.usage.userTime,
+		SystemTime: mp.usage.systemTime,
+		MaxRSS:     mp.usage.maxRSS,
+	}, mp.usage.runs > 0
+}
+
+// add accumulates the other entry of the same command, keeping its exit code as the latest.
+func (e *ResourceReportEntry) add(other ResourceReportEntry) {
+	e.Runs += other.Runs
+	e.ExitCode = other.ExitCode
+	e.Wall += other.Wall
+	e.UserTime += other.UserTime
+	e.SystemTime += other.SystemTime
+	e.MaxRSS = max(e.MaxRSS, other.MaxRSS)
+}
+
+func (r *managedProcRegistry) resourceReport() []ResourceReportEntry {
+	entries := []ResourceReportEntry{}
+	for _, mp := range r.all() {
+		if entry, ran := mp.reportEntry(); ran {
+			entries = append(entries, entry)
+		}
+	}
+	r.mu.Lock()
+	for _, entry := range r.finished {
+		entries = append(entries, *entry)
+	}
+	r.mu.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Wall > entries[j].Wall
+	})
+	return entries
+}
+
+func resourceTable(entries []ResourceReportEntry) string {
+	var total time.Duration
+	for _, entry := range entries {
+		total += entry.Wall
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(color.CyanString("Resources consumed by %d processes, %s in total:", len(entries), total.Round(time.Second)) + "\n")
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "  WALL\tUSER\tSYS\tMAX RSS\tRUNS\tEXIT\tCOMMAND")
+	for i, entry := range entries {
+		if i == reportTableRows {
+			_, _ = fmt.Fprintf(tw, "  ...\t\t\t\t\t\t%d more\n", len(entries)-reportTableRows)
+			break
+		}
+		_, _ = fmt.Fprintf(
+			tw, "  %s\t%s\t%s\t%.1f MiB\t%d\t%d\t%s\n",
+			entry.Wall.Round(time.Millisecond),
+			entry.UserTime.Round(time.Millisecond),
+			entry.SystemTime.Round(time.Millisecond),
+			float64(entry.MaxRSS)/1024/1024,
+			entry.Runs,
+			entry.ExitCode,
+			entry.Command,
+		)
+	}
+	_ = tw.Flush()
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func writeResourceReport(path string, entries []ResourceReportEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0640)
+}
@@ -0,0 +1,79 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useProcRegistry isolates the processes run by the test from the other tests.
+func useProcRegistry(t *testing.T) {
+	previous := procRegistry
+	procRegistry = &managedProcRegistry{finished: map[string]*ResourceReportEntry{}}
+	t.Cleanup(func() {
+		procRegistry = previous
+	})
+}
+
+func TestResourceReport(t *testing.T) {
+	useProcRegistry(t)
+	fast := NewManagedProc(t.Context(), "sh", "-c", "exit 3")
+	_ = fast.Run()
+	slow := NewManagedProc(t.Context(), "sh", "-c", "sleep 0.3")
+	slow.Restart = RestartPolicy{Policy: RestartAlways, MaxRetries: 1, Backoff: time.Millisecond}
+	_ = slow.Run()
+
+	entries := procRegistry.resourceReport()
+	commands := []string{}
+	for _, entry := range entries {
+		commands = append(commands, entry.Command)
+	}
+	if expected := []string{slow.visual(), fast.visual()}; !slices.Equal(commands, expected) {
+		t.Fatalf("expected most time-consuming first %q, got %q", expected, commands)
+	}
+	if entries[0].Runs != 2 || entries[0].Wall < 600*time.Millisecond || entries[0].MaxRSS == 0 {
+		t.Errorf("expected usage of both runs, got %+v", entries[0])
+	}
+	if entries[1].Runs != 1 || entries[1].ExitCode != 3 {
+		t.Errorf("expected single failed run, got %+v", entries[1])
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := writeResourceReport(path, entries); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []ResourceReportEntry
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(decoded, entries) {
+		t.Errorf("expected JSON to round-trip %+v, got %+v", entries, decoded)
+	}
+	if !strings.Contains(string(content), `"wallNs": `) {
+		t.Errorf("expected wall time in nanoseconds, got %s", content)
+	}
+}
+
+func TestResourceTableRows(t *testing.T) {
+	var entries []ResourceReportEntry
+	for i := range reportTableRows + 5 {
+		entries = append(entries, ResourceReportEntry{Command: fmt.Sprintf("cmd-%d", i), Runs: 1, Wall: time.Second})
+	}
+
+	table := resourceTable(entries)
+	if !strings.Contains(table, fmt.Sprintf("Resources consumed by %d processes, %s in total", len(entries), time.Duration(len(entries))*time.Second)) {
+		t.Errorf("expected all the processes summed up, got:\n%s", table)
+	}
+	if strings.Contains(table, fmt.Sprintf("cmd-%d\n", reportTableRows)) || !strings.Contains(table, "5 more") {
+		t.Errorf("expected the table limited to %d rows, got:\n%s", reportTableRows, table)
+	}
+}
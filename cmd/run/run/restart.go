@@ -0,0 +1,76 @@
+package run
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+type RestartMode int
+
+const (
+	// RestartNever runs the process once, the default.
+	RestartNever RestartMode = iota
+	// RestartOnFailure starts the process again when it exits with an error.
+	RestartOnFailure
+	// RestartAlways starts the process again whenever it exits.
+	RestartAlways
+)
+
+const (
+	defaultRestartBackoff    = time.Second
+	defaultRestartMaxBackoff = 30 * time.Second
+)
+
+// RestartPolicy configures restarts of a ManagedProc.
+// The delay between restarts starts at Backoff and doubles with every attempt, up to MaxBackoff.
+type RestartPolicy struct {
+	Policy RestartMode
+	// MaxRetries limits the number of restarts, 0 for unlimited.
+	MaxRetries int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func (rp RestartPolicy) shouldRestart(ctx context.Context, err error, attempt int) bool {
+	// Interrupted, or stopped
+	if ctx.Err() != nil {
+		return false
+	}
+	if rp.MaxRetries > 0 && attempt > rp.MaxRetries {
+		return false
+	}
+
+	switch rp.Policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	default:
+		return false
+	}
+}
+
+// backoff computes the delay before the restart following the attempt-th run.
+func (rp RestartPolicy) backoff(attempt int) time.Duration {
+	delay := rp.Backoff
+	if delay <= 0 {
+		delay = defaultRestartBackoff
+	}
+	maxDelay := rp.MaxBackoff
+	if maxDelay <= 0 {
+		maxDelay = defaultRestartMaxBackoff
+	}
+
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
+
+func (rp RestartPolicy) retriesVisual() string {
+	if rp.MaxRetries == 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(rp.MaxRetries)
+}
@@ -0,0 +1,74 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestartCounting(t *testing.T) {
+	tests := []struct {
+		name      string
+		script    string
+		policy    RestartMode
+		expectErr bool
+		runs      int
+	}{
+		{"never", "exit 1", RestartNever, true, 1},
+		{"on failure, failing", "exit 1", RestartOnFailure, true, 3},
+		{"on failure, succeeding", "exit 0", RestartOnFailure, false, 1},
+		{"always", "exit 0", RestartAlways, false, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runs := filepath.Join(t.TempDir(), "runs")
+			mp := NewManagedProc(t.Context(), "sh", "-c", "echo run >> "+runs+"; "+test.script)
+			mp.Restart = RestartPolicy{Policy: test.policy, MaxRetries: 2, Backoff: 10 * time.Millisecond}
+
+			err := mp.Run()
+			if test.expectErr != (err != nil) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			content, err := os.ReadFile(runs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count := strings.Count(string(content), "run"); count != test.runs {
+				t.Errorf("expected %d runs, got %d", test.runs, count)
+			}
+		})
+	}
+}
+
+func TestRestartStopped(t *testing.T) {
+	mp := NewManagedProc(t.Context(), "sh", "-c", "exit 1")
+	mp.Restart = RestartPolicy{Policy: RestartAlways, Backoff: time.Minute}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		mp.Stop()
+	}()
+
+	start := time.Now()
+	if err := mp.Run(); err == nil {
+		t.Error("expected the last failure returned")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected stopping to cut the backoff short, took %s", elapsed)
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	policy := RestartPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, delay := range expected {
+		if actual := policy.backoff(i + 1); actual != delay {
+			t.Errorf("expected backoff %s after attempt %d, got %s", delay, i+1, actual)
+		}
+	}
+
+	if actual := (RestartPolicy{}).backoff(10); actual != defaultRestartMaxBackoff {
+		t.Errorf("expected default max backoff %s, got %s", defaultRestartMaxBackoff, actual)
+	}
+}
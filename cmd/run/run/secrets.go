@@ -0,0 +1,63 @@
+package run
+
+import (
+	"encoding/base64"
+	"strings"
+	"sync"
+)
+
+const (
+	redacted = "*REDACTED*"
+	// Shorter values would redact too much of unrelated output
+	secretMinLen = 4
+)
+
+var secrets = &secretRegistry{}
+
+type secretRegistry struct {
+	mu sync.RWMutex
+	// forms holds all the representations to redact, longest first so the encoded forms are not redacted partially
+	forms []string
+}
+
+// RegisterSecret makes the value, as well as its base64 encoded forms, redacted in all
+// ManagedProc command lines, outputs and logs from now on.
+func RegisterSecret(secret string) {
+	if len(secret) < secretMinLen {
+		return
+	}
+
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	for _, form := range []string{
+		secret,
+		base64.StdEncoding.EncodeToString([]byte(secret)),
+		// Padding depends on what follows the secret, in case it is encoded as a part of a longer string
+		base64.RawStdEncoding.EncodeToString([]byte(secret)),
+	} {
+		secrets.add(form)
+	}
+}
+
+func (r *secretRegistry) add(form string) {
+	for i, existing := range r.forms {
+		if existing == form {
+			return
+		}
+		if len(form) > len(existing) {
+			r.forms = append(r.forms[:i], append([]string{form}, r.forms[i:]...)...)
+			return
+		}
+	}
+	r.forms = append(r.forms, form)
+}
+
+// Redact replaces all registered secrets in the string.
+func Redact(in string) string {
+	secrets.mu.RLock()
+	defer secrets.mu.RUnlock()
+	for _, form := range secrets.forms {
+		in = strings.ReplaceAll(in, form, redacted)
+	}
+	return in
+}
@@ -0,0 +1,55 @@
+package run
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	RegisterSecret("s3cr3t-value")
+	RegisterSecret("abc") // Too short to be redacted
+
+	encoded := base64.StdEncoding.EncodeToString([]byte("s3cr3t-value"))
+	in := "plain s3cr3t-value, encoded " + encoded + ", short abc"
+	expected := "plain *REDACTED*, encoded *REDACTED*, short abc"
+	if out := Redact(in); out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestOutputRedacted(t *testing.T) {
+	dir, err := InitLogDir(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		logDir = ""
+	})
+
+	mp := NewManagedProc(t.Context(), "sh", "-c", "echo token=hunter2-in-output")
+	mp.Mask("hunter2-in-output")
+	captured := mp.CaptureStdout()
+	if err := mp.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Captured data are needed verbatim
+	if !strings.Contains(captured.String(), "hunter2-in-output") {
+		t.Errorf("captured output expected not to be redacted: %q", captured)
+	}
+
+	logs, err := filepath.Glob(dir + "/*.log")
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("expected one log file, got %v, %v", logs, err)
+	}
+	logged, err := os.ReadFile(logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(logged), "hunter2") || !strings.Contains(string(logged), "token=*REDACTED*") {
+		t.Errorf("log expected to be redacted: %q", logged)
+	}
+}
@@ -0,0 +1,130 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// procRegistry keeps track of the running ManagedProcs, and of the resources consumed by the finished ones.
+var procRegistry = &managedProcRegistry{finished: map[string]*ResourceReportEntry{}}
+
+type managedProcRegistry struct {
+	mu    sync.Mutex
+	procs []*ManagedProc
+	// finished accumulates the usage of the processes removed once they return, per command, so the registry
+	// does not grow with repeated runs, like polls
+	finished      map[string]*ResourceReportEntry
+	finishedCount int
+}
+
+func (r *managedProcRegistry) add(mp *ManagedProc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.procs = append(r.procs, mp)
+}
+
+// remove drops the returned process, keeping only its resource usage for the report.
+func (r *managedProcRegistry) remove(mp *ManagedProc) {
+	entry, ran := mp.reportEntry()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.procs = slices.DeleteFunc(r.procs, func(p *ManagedProc) bool {
+		return p == mp
+	})
+	if !ran {
+		return
+	}
+	r.finishedCount++
+	if acc, found := r.finished[entry.Command]; found {
+		acc.add(entry)
+	} else {
+		r.finished[entry.Command] = &entry
+	}
+}
+
+func (r *managedProcRegistry) all() []*ManagedProc {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*ManagedProc(nil), r.procs...)
+}
+
+// procSnapshot is a consistent view of ManagedProc runtime state.
+type procSnapshot struct {
+	visual   string
+	status   managedProcStatus
+	active   bool
+	pid      int
+	started  time.Time
+	exitCode int
+}
+
+func (mp *ManagedProc) snapshot() procSnapshot {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return procSnapshot{mp.visual(), mp.status, mp.active, mp.pid, mp.started, mp.exitCode}
+}
+
+// StartStatusReporter periodically prints the state of all the running ManagedProcs to stderr,
+// so it is clear what the tool is waiting on.
+func StartStatusReporter(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if table := procRegistry.statusTable(); table != "" {
+					Out(os.Stderr, "%s", table)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// statusTable renders the processes currently running, or empty string when there are none.
+func (r *managedProcRegistry) statusTable() string {
+	var active []procSnapshot
+	for _, mp := range r.all() {
+		if snap := mp.snapshot(); snap.active {
+			active = append(active, snap)
+		}
+	}
+	r.mu.Lock()
+	finished := r.finishedCount
+	r.mu.Unlock()
+	if len(active) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(color.CyanString("Processes running (%d finished):", finished) + "\n")
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "  STATE\tPID\tUPTIME\tEXIT\tCOMMAND")
+	for _, snap := range active {
+		pid, uptime, exitCode := "-", "-", "-"
+		if snap.pid != 0 {
+			pid = strconv.Itoa(snap.pid)
+			uptime = time.Since(snap.started).Truncate(time.Second).String()
+		}
+		if snap.exitCode >= 0 {
+			exitCode = strconv.Itoa(snap.exitCode)
+		}
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", snap.status, pid, uptime, exitCode, snap.visual)
+	}
+	_ = tw.Flush()
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
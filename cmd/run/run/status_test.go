@@ -0,0 +1,52 @@
+package run
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRegistryDropsFinishedProcs(t *testing.T) {
+	useProcRegistry(t)
+	var last *ManagedProc
+	for range 3 {
+		last = NewManagedProc(t.Context(), "sh", "-c", "exit 2", "registry")
+		_ = last.Run()
+	}
+
+	if slices.Contains(procRegistry.all(), last) {
+		t.Errorf("expected finished process removed from the registry")
+	}
+	entries := slices.DeleteFunc(procRegistry.resourceReport(), func(entry ResourceReportEntry) bool {
+		return entry.Command != last.visual()
+	})
+	if len(entries) != 1 || entries[0].Runs != 3 || entries[0].ExitCode != 2 {
+		t.Errorf("expected one entry accumulating the runs, got %+v", entries)
+	}
+}
+
+func TestStatusTable(t *testing.T) {
+	useProcRegistry(t)
+	if table := procRegistry.statusTable(); table != "" {
+		t.Errorf("expected no table without processes, got:\n%s", table)
+	}
+	_ = NewManagedProc(t.Context(), "true").Run()
+
+	mp := NewManagedProc(t.Context(), "sh", "-c", "echo started; sleep 5")
+	mp.AddReadinessProbe(LogProbe(`started`))
+	runInBackground(t, mp)
+	if err := mp.WaitReady(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	table := procRegistry.statusTable()
+	row := fmt.Sprintf(`(?m)^  ready\s+%d\s+\d+s\s+-\s+%s$`, mp.snapshot().pid, regexp.QuoteMeta(mp.visual()))
+	if !regexp.MustCompile(row).MatchString(table) {
+		t.Errorf("expected row matching %q, got:\n%s", row, table)
+	}
+	if !regexp.MustCompile(`\(1 finished\)`).MatchString(table) {
+		t.Errorf("expected the finished process counted, got:\n%s", table)
+	}
+}
@@ -0,0 +1,20 @@
+package run
+
+import "testing"
+
+// UseExecutor makes the ManagedProcs of the test use the executor, restoring the previous one when the test completes.
+// Tests replacing the executor cannot run in parallel, as it is global.
+func UseExecutor(t testing.TB, e Executor) {
+	previous := executor
+	SetExecutor(e)
+	t.Cleanup(func() {
+		SetExecutor(previous)
+	})
+}
+
+// UseFakeExecutor makes the ManagedProcs of the test run by a new FakeExecutor, see UseExecutor.
+func UseFakeExecutor(t testing.TB) *FakeExecutor {
+	fake := NewFakeExecutor()
+	UseExecutor(t, fake)
+	return fake
+}
@@ -2,12 +2,12 @@ package run
 
 import (
 	"bufio"
+	"context"
 	"encoding/base64"
 	"fmt"
 	"log"
 	"net"
 	"os"
-	"os/exec"
 	"regexp"
 	"strings"
 
@@ -55,10 +55,10 @@ func CheckMarker(filePath string, regexPattern *regexp.Regexp) error {
 	return fmt.Errorf("not run from project directory")
 }
 
-func CheckDocker() error {
-	mp := NewManagedProc([]string{"docker", "ps"}...)
+func CheckDocker(ctx context.Context) error {
+	mp := NewManagedProc(ctx, "docker", "ps")
 	if err := mp.Run(); err != nil {
-		return fmt.Errorf("docker not running: %s", err)
+		return fmt.Errorf("docker not running: %w", err)
 	}
 	return nil
 }
@@ -77,14 +77,17 @@ func GetOutboundIP() string {
 
 func RandomPwdBase64() string {
 	pwd := password.MustGenerate(10, 3, 1, false, true)
+	RegisterSecret(pwd)
 	return base64.StdEncoding.EncodeToString([]byte(pwd))
 }
 
-func CopyToClipboard(argoCdSecret string) error {
-	cmd := exec.Command("xclip")
-	cmd.Stdin = strings.NewReader(argoCdSecret)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("xclip failed: %s", err)
+func CopyToClipboard(ctx context.Context, argoCdSecret string) error {
+	mp := NewManagedProc(ctx, "xclip")
+	mp.Stdin(strings.NewReader(argoCdSecret))
+	// Keeps serving the selection in the background
+	mp.Daemonizes = true
+	if err := mp.Run(); err != nil {
+		return fmt.Errorf("xclip failed: %w", err)
 	}
 	return nil
 }
@@ -8,6 +8,7 @@ require (
 	github.com/fatih/color v1.18.0
 	github.com/sethvargo/go-password v0.3.1
 	github.com/spf13/cobra v1.10.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (